@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultNasnePort is used for probe targets given without a port.
+const defaultNasnePort = 64210
+
+// allowlist restricts which devices /probe may contact. Entries are CIDRs,
+// which are checked against the resolved addresses, or hostnames, where
+// "*.example.lan" also matches every subdomain.
+type allowlist struct {
+	prefixes []netip.Prefix
+	hosts    []string
+	resolver interface {
+		LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+	}
+}
+
+func parseAllowlist(entries []string) (*allowlist, error) {
+	a := &allowlist{resolver: net.DefaultResolver}
+	for _, e := range entries {
+		if strings.Contains(e, "/") {
+			prefix, err := netip.ParsePrefix(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", e, err)
+			}
+			a.prefixes = append(a.prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(e); err == nil {
+			a.prefixes = append(a.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		host := strings.ToLower(strings.TrimSuffix(e, "."))
+		if strings.Contains(host, "*") {
+			// A wildcard must name a domain; a bare "*" would allow every host.
+			rest, ok := strings.CutPrefix(host, "*.")
+			if !ok || rest == "" || strings.Contains(rest, "*") {
+				return nil, fmt.Errorf("invalid host pattern %q: want *.<domain>", e)
+			}
+		}
+		a.hosts = append(a.hosts, host)
+	}
+	return a, nil
+}
+
+func (a *allowlist) empty() bool {
+	return len(a.prefixes) == 0 && len(a.hosts) == 0
+}
+
+// pin checks target (host[:port] or an http(s) URL) against the allowlist
+// and returns the base URL to scrape along with the target label. Targets
+// allowed by CIDR are rewritten to the checked IP address, so a DNS answer
+// changing between the check and the request cannot redirect the probe.
+func (a *allowlist) pin(ctx context.Context, target string) (rawURL, label string, err error) {
+	raw := target
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", fmt.Errorf("invalid target %q: want host[:port] or an http(s) URL without credentials or query", target)
+	}
+	port := u.Port()
+	if port == "" && u.Path == "" {
+		port = strconv.Itoa(defaultNasnePort)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	u.Host = joinHostPort(host, port)
+	label = safeTargetLabel(u.String())
+	if a.hostAllowed(host) {
+		return u.String(), label, nil
+	}
+
+	addrs := []netip.Addr{}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else {
+		addrs, err = a.resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return "", "", fmt.Errorf("resolve target %q: %w", host, err)
+		}
+	}
+	if len(addrs) == 0 {
+		return "", "", fmt.Errorf("target %q has no addresses", host)
+	}
+	for _, addr := range addrs {
+		if !a.addrAllowed(addr.Unmap()) {
+			return "", "", fmt.Errorf("target %q (%s) is not in the probe allowlist", target, addr)
+		}
+	}
+	u.Host = joinHostPort(addrs[0].Unmap().String(), port)
+	return u.String(), label, nil
+}
+
+func (a *allowlist) hostAllowed(host string) bool {
+	for _, h := range a.hosts {
+		if suffix, ok := strings.CutPrefix(h, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *allowlist) addrAllowed(addr netip.Addr) bool {
+	for _, p := range a.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinHostPort(host, port string) string {
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/exporter"
+)
+
+// configFile is the --config.file document. Both sections are kept as YAML
+// nodes so that errors can point at the offending line.
+type configFile struct {
+	// Global sets flags by name, with dashes written as underscores
+	// (http_timeout for --http-timeout).
+	Global yaml.Node `yaml:"global"`
+	// Targets replace --nasne-url; each entry takes the same settings as the
+	// query parameters of a --nasne-url entry.
+	Targets []yaml.Node `yaml:"targets"`
+}
+
+// loadConfig reads and strictly decodes the YAML file at path.
+func loadConfig(path string) (*configFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	var cfg configFile
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Global.Kind != 0 && cfg.Global.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: line %d: global must be a mapping", path, cfg.Global.Line)
+	}
+	return &cfg, nil
+}
+
+// applyGlobal sets the flags listed in the global section, except those given
+// on the command line or through their environment variable.
+func (cfg *configFile) applyGlobal(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	overridden := func(name string) bool {
+		return explicit[name] || envOverrides(fs.Lookup(name))
+	}
+
+	pairs := cfg.Global.Content
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, node := pairs[i], pairs[i+1]
+		value, err := nodeValue(node)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", node.Line, key.Value, err)
+		}
+		if key.Value == "collectors" {
+			enabled := splitCSV(value)
+			if err := exporter.ValidateSubCollectors(enabled); err != nil {
+				return fmt.Errorf("line %d: collectors: %w", node.Line, err)
+			}
+			for _, name := range exporter.SubCollectorNames() {
+				if overridden("collector."+name) || overridden("no-collector."+name) {
+					continue
+				}
+				if err := fs.Set("collector."+name, strconv.FormatBool(slices.Contains(enabled, name))); err != nil {
+					return fmt.Errorf("line %d: collectors: %w", node.Line, err)
+				}
+			}
+			continue
+		}
+
+		name := strings.ReplaceAll(key.Value, "_", "-")
+		if fs.Lookup(name) == nil || !fileSettable(name) {
+			return fmt.Errorf("line %d: unknown global setting %q", key.Line, key.Value)
+		}
+		if overridden(name) {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("line %d: %s: %w", node.Line, key.Value, err)
+		}
+	}
+	return nil
+}
+
+// targets returns the target entries applied on top of defaults.
+func (cfg *configFile) targets(defaults targetConfig) ([]targetConfig, error) {
+	out := make([]targetConfig, 0, len(cfg.Targets))
+	for i := range cfg.Targets {
+		target, err := parseFileTarget(&cfg.Targets[i], defaults)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, target)
+	}
+	return out, nil
+}
+
+// parseFileTarget converts one entry of the targets list. The url may carry
+// query parameters like a --nasne-url entry; the other keys are applied
+// after them.
+func parseFileTarget(n *yaml.Node, defaults targetConfig) (targetConfig, error) {
+	if n.Kind != yaml.MappingNode {
+		return targetConfig{}, fmt.Errorf("line %d: target must be a mapping", n.Line)
+	}
+	var rawURL *yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == "url" {
+			rawURL = n.Content[i+1]
+		}
+	}
+	if rawURL == nil || rawURL.Kind != yaml.ScalarNode || rawURL.Value == "" {
+		return targetConfig{}, fmt.Errorf("line %d: target without url", n.Line)
+	}
+	cfg, err := parseTarget(rawURL.Value, defaults)
+	if err != nil {
+		return targetConfig{}, fmt.Errorf("line %d: %w", rawURL.Line, err)
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, node := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "url":
+			continue
+		case "labels", "headers":
+			if node.Kind != yaml.MappingNode {
+				return targetConfig{}, fmt.Errorf("line %d: %s must be a mapping", node.Line, key.Value)
+			}
+			prefix := strings.TrimSuffix(key.Value, "s") + "."
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				name, value := node.Content[j], node.Content[j+1]
+				if value.Kind != yaml.ScalarNode {
+					return targetConfig{}, fmt.Errorf("line %d: %s.%s: expected a value", value.Line, key.Value, name.Value)
+				}
+				if err := cfg.set(prefix+name.Value, value.Value); err != nil {
+					return targetConfig{}, fmt.Errorf("line %d: %s.%s: %w", name.Line, key.Value, name.Value, err)
+				}
+			}
+			continue
+		}
+		value, err := nodeValue(node)
+		if err == nil {
+			err = cfg.set(key.Value, value)
+		} else if errors.Is((&targetConfig{}).set(key.Value, ""), errUnknownParameter) {
+			err = errUnknownParameter
+		}
+		if err != nil {
+			return targetConfig{}, fmt.Errorf("line %d: %s: %w", key.Line, key.Value, err)
+		}
+	}
+	return cfg, nil
+}
+
+// nodeValue returns a scalar as is and a list of scalars as a comma-separated
+// string, the format of the corresponding flags.
+func nodeValue(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Value, nil
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("expected a list of values")
+			}
+			values = append(values, item.Value)
+		}
+		return strings.Join(values, ","), nil
+	}
+	return "", errors.New("expected a value or a list of values")
+}
+
+// fileSettable reports whether the flag name may appear in the global
+// section of the config file. Targets are listed in the targets section, so
+// nasne-url is not accepted there.
+func fileSettable(name string) bool {
+	switch name {
+	case "version", "config.file", "nasne-url":
+		return false
+	}
+	return !strings.HasPrefix(name, "no-collector.")
+}
+
+// envOverrides reports whether the environment variable of f holds a usable
+// value. envDuration, envInt and envBool fall back to the built-in default
+// for a value that does not parse, so such a value leaves the file setting in
+// effect.
+func envOverrides(f *flag.Flag) bool {
+	if f == nil {
+		return false
+	}
+	v := strings.TrimSpace(os.Getenv(envName(f.Name)))
+	if v == "" {
+		return false
+	}
+	var err error
+	if g, ok := f.Value.(flag.Getter); ok {
+		switch g.Get().(type) {
+		case time.Duration:
+			_, err = time.ParseDuration(v)
+		case int:
+			_, err = strconv.Atoi(v)
+		case bool:
+			_, err = strconv.ParseBool(v)
+		}
+	}
+	return err == nil
+}
+
+// envName returns the environment variable that sets the flag name, e.g.
+// HTTP_TIMEOUT for http-timeout and COLLECTOR_TUNER for collector.tuner.
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nasne_exporter.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+global:
+  http_timeout: 3s
+  scrape_timeout: 20s
+  retry_status_codes: [502, 503]
+  collectors: [device, tuner]
+targets:
+  - url: http://192.168.11.1:64210?interface=eth0
+    alias: living
+    labels:
+      room: living
+    headers:
+      X-Api-Key: k
+    scrape_timeout: 30s
+    collectors: [tuner]
+  - url: https://proxy.example/nasne2
+    recorded_url: https://proxy.example/nasne2-recorded
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	httpTimeout := fs.Duration("http-timeout", 5*time.Second, "")
+	scrapeTimeout := fs.Duration("scrape-timeout", 10*time.Second, "")
+	retryStatus := fs.String("retry-status-codes", "502,503,504", "")
+	device := fs.Bool("collector.device", true, "")
+	storage := fs.Bool("collector.storage", true, "")
+	tuner := fs.Bool("collector.tuner", true, "")
+	for _, name := range []string{"dtcpip", "recorded", "reservations"} {
+		fs.Bool("collector."+name, true, "")
+	}
+	if err := fs.Parse([]string{"--http-timeout=1s"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	t.Setenv("RETRY_STATUS_CODES", "504")
+	t.Setenv("SCRAPE_TIMEOUT", "garbage")
+
+	if err := cfg.applyGlobal(fs); err != nil {
+		t.Fatalf("apply global: %v", err)
+	}
+	if *httpTimeout != time.Second {
+		t.Errorf("command line flag overridden: http-timeout = %s", *httpTimeout)
+	}
+	if *retryStatus != "502,503,504" {
+		t.Errorf("flag set by environment overridden: retry-status-codes = %q", *retryStatus)
+	}
+	if *scrapeTimeout != 20*time.Second {
+		t.Errorf("scrape-timeout = %s, want 20s from the file over an unparsable SCRAPE_TIMEOUT", *scrapeTimeout)
+	}
+	if !*device || *storage || !*tuner {
+		t.Errorf("collectors device=%t storage=%t tuner=%t, want only device and tuner", *device, *storage, *tuner)
+	}
+
+	defaults := targetConfig{HTTPTimeout: *httpTimeout, ScrapeTimeout: *scrapeTimeout, Collectors: []string{"device", "tuner"}}
+	targets, err := cfg.targets(defaults)
+	if err != nil {
+		t.Fatalf("targets: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("got %d targets, want 2", len(targets))
+	}
+	living := targets[0]
+	if living.URL != "http://192.168.11.1:64210" || living.Transport.Interface != "eth0" || living.label() != "living" ||
+		!reflect.DeepEqual(living.Labels, map[string]string{"room": "living"}) ||
+		!reflect.DeepEqual(living.Headers, []string{"X-Api-Key: k"}) ||
+		living.ScrapeTimeout != 30*time.Second || living.HTTPTimeout != time.Second ||
+		!reflect.DeepEqual(living.Collectors, []string{"tuner"}) {
+		t.Errorf("unexpected first target: %+v", living)
+	}
+	if proxied := targets[1]; proxied.RecordedURL != "https://proxy.example/nasne2-recorded" || proxied.ScrapeTimeout != 20*time.Second || proxied.label() != "proxy.example:443/nasne2" {
+		t.Errorf("unexpected second target: %+v", proxied)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		wantErr string
+	}{
+		"unknown section": {
+			content: "global: {}\nscrape_configs: []\n",
+			wantErr: "line 2: field scrape_configs not found",
+		},
+		"unknown global setting": {
+			content: "global:\n  http_timeot: 1s\n",
+			wantErr: `line 2: unknown global setting "http_timeot"`,
+		},
+		"invalid global value": {
+			content: "global:\n  http_timeout: soon\n",
+			wantErr: "line 2: http_timeout:",
+		},
+		"unknown collector": {
+			content: "global:\n  collectors: [device, epg]\n",
+			wantErr: `line 2: collectors: unknown collector "epg"`,
+		},
+		"command line only": {
+			content: "global:\n  version: true\n",
+			wantErr: `line 2: unknown global setting "version"`,
+		},
+		"nasne url in global": {
+			content: "global:\n  nasne_url: http://192.168.11.1:64210\ntargets:\n  - url: http://192.168.11.2:64210\n",
+			wantErr: `line 2: unknown global setting "nasne_url"`,
+		},
+		"target without url": {
+			content: "targets:\n  - alias: living\n",
+			wantErr: "line 2: target without url",
+		},
+		"unknown target setting": {
+			content: "targets:\n  - url: http://192.168.11.1:64210\n    lables:\n      room: living\n",
+			wantErr: "line 3: lables: unknown target parameter",
+		},
+		"invalid target value": {
+			content: "targets:\n  - url: http://192.168.11.1:64210\n    scrape_timeout: 10\n",
+			wantErr: "line 3: scrape_timeout:",
+		},
+		"reserved label name": {
+			content: "targets:\n  - url: http://192.168.11.1:64210\n    labels:\n      target: x\n",
+			wantErr: `line 4: labels.target: label name "target" is reserved`,
+		},
+		"invalid label name": {
+			content: "targets:\n  - url: http://192.168.11.1:64210\n    labels:\n      room-name: x\n",
+			wantErr: `line 4: labels.room-name: invalid label name "room-name"`,
+		},
+		"no target collectors": {
+			content: "targets:\n  - url: http://192.168.11.1:64210\n    collectors: []\n",
+			wantErr: "line 3: collectors: at least one collector must be enabled",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := loadConfig(writeConfig(t, tt.content))
+			if err == nil {
+				fs := flag.NewFlagSet("test", flag.ContinueOnError)
+				fs.Duration("http-timeout", 5*time.Second, "")
+				fs.Bool("version", false, "")
+				fs.String("nasne-url", "", "")
+				for _, name := range []string{"device", "storage", "tuner", "dtcpip", "recorded", "reservations"} {
+					fs.Bool("collector."+name, true, "")
+				}
+				err = cfg.applyGlobal(fs)
+			}
+			if err == nil {
+				_, err = cfg.targets(targetConfig{})
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/ryomaholiday/nasne_exporter/internal/exporter"
@@ -19,32 +26,210 @@ import (
 
 func main() {
 	var (
+		configPath    = flag.String("config.file", envOrDefault("CONFIG_FILE", ""), "YAML configuration file with global settings and per-target settings; flags and environment variables override it")
 		nasneURLCSV   = flag.String("nasne-url", envOrDefault("NASNE_URL", ""), "comma-separated nasne base URLs (e.g. http://192.168.11.1:64210,http://192.168.11.2:64210)")
 		listenAddress = flag.String("listen-address", envOrDefault("LISTEN_ADDRESS", ":9900"), "address to listen on")
 		metricsPath   = flag.String("metrics-path", envOrDefault("METRICS_PATH", "/metrics"), "metrics HTTP path")
 		healthPath    = flag.String("health-path", envOrDefault("HEALTH_PATH", "/healthz"), "health check path")
+		schemaCheck   = flag.Bool("schema-check", envBool("SCHEMA_CHECK", false), "compare nasne responses with the decoded fields and export nasne_schema_unexpected_fields")
+		schemaPath    = flag.String("schema-debug-path", envOrDefault("SCHEMA_DEBUG_PATH", "/debug/schema"), "path of the schema check debug endpoint (only with --schema-check)")
 		httpTimeout   = flag.Duration("http-timeout", envDuration("HTTP_TIMEOUT", 5*time.Second), "timeout per HTTP request to nasne")
 		scrapeTimeout = flag.Duration("scrape-timeout", envDuration("SCRAPE_TIMEOUT", 10*time.Second), "timeout for each target scrape")
+		listPageSize  = flag.Int("list-page-size", envInt("LIST_PAGE_SIZE", 100), "number of items requested per page from recorded/schedule list endpoints")
+		concurrency   = flag.Int("max-concurrency", envInt("MAX_CONCURRENCY", 4), "maximum concurrent HTTP requests per nasne")
+		retryAttempts = flag.Int("retry-max-attempts", envInt("RETRY_MAX_ATTEMPTS", 1), "maximum attempts per nasne request including the first (1 disables retries)")
+		retryInitial  = flag.Duration("retry-initial-backoff", envDuration("RETRY_INITIAL_BACKOFF", 200*time.Millisecond), "upper bound of the first jittered retry backoff; doubles per retry")
+		retryMax      = flag.Duration("retry-max-backoff", envDuration("RETRY_MAX_BACKOFF", 2*time.Second), "maximum retry backoff")
+		retryStatus   = flag.String("retry-status-codes", envOrDefault("RETRY_STATUS_CODES", "502,503,504"), "comma-separated HTTP status codes that are retried")
+		retryBudget   = flag.Duration("retry-budget", envDuration("RETRY_BUDGET", 0), "total time allowed per request including retries (0 = bounded by scrape timeout only)")
+		breakerFails  = flag.Int("breaker-failure-threshold", envInt("BREAKER_FAILURE_THRESHOLD", 0), "consecutive failed scrapes before a target's circuit breaker opens (0 disables the breaker)")
+		breakerOpen   = flag.Duration("breaker-open-duration", envDuration("BREAKER_OPEN_DURATION", time.Minute), "how long an open circuit breaker fails fast before probing the device again")
+		pollInterval  = flag.Duration("poll-interval", envDuration("POLL_INTERVAL", 0), "poll each nasne in the background at this interval and serve /metrics from the cached snapshot (0 queries nasne on every scrape)")
+		pollJitter    = flag.Duration("poll-jitter", envDuration("POLL_JITTER", 5*time.Second), "random delay of up to this much added to every poll")
+		maxStaleness  = flag.Duration("max-staleness", envDuration("MAX_STALENESS", 5*time.Minute), "in polling mode, report nasne_up 0 once the cached snapshot is older than this (0 = never)")
+		dtcpipInfo    = flag.Bool("dtcpip-client-info", envBool("DTCPIP_CLIENT_INFO", false), "export nasne_dtcpip_client_info with details of connected DTCP-IP clients")
+		dtcpipLimit   = flag.Int("dtcpip-client-info-limit", envInt("DTCPIP_CLIENT_INFO_LIMIT", 10), "maximum number of DTCP-IP clients exported per target")
+		caFile        = flag.String("http-ca-file", envOrDefault("HTTP_CA_FILE", ""), "PEM bundle trusted in addition to the system roots for https nasne URLs")
+		tlsInsecure   = flag.Bool("http-insecure-skip-verify", envBool("HTTP_INSECURE_SKIP_VERIFY", false), "skip TLS certificate verification for https nasne URLs")
+		noKeepAlive   = flag.Bool("http-disable-keepalives", envBool("HTTP_DISABLE_KEEPALIVES", false), "close the connection to nasne after every request")
+		tcpKeepAlive  = flag.Duration("http-tcp-keepalive", envDuration("HTTP_TCP_KEEPALIVE", 30*time.Second), "TCP keep-alive period for connections to nasne (negative disables)")
+		maxIdle       = flag.Int("http-max-idle-conns", envInt("HTTP_MAX_IDLE_CONNS", 100), "maximum idle connections per target")
+		maxIdlePer    = flag.Int("http-max-idle-conns-per-host", envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 4), "maximum idle connections per nasne host and port")
+		idleTimeout   = flag.Duration("http-idle-conn-timeout", envDuration("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second), "how long idle connections to nasne are kept")
+		userAgent     = flag.String("http-user-agent", envOrDefault("HTTP_USER_AGENT", "nasne_exporter"), "User-Agent header sent to nasne")
+		sourceAddress = flag.String("http-source-address", envOrDefault("HTTP_SOURCE_ADDRESS", ""), "local IP address to connect to nasne from")
+		sourceIface   = flag.String("http-interface", envOrDefault("HTTP_INTERFACE", ""), "network interface whose address is used to connect to nasne (exclusive with --http-source-address)")
+		proxyURL      = flag.String("proxy-url", envOrDefault("PROXY_URL", ""), "proxy for connections to nasne: socks5://host:port or http://host:port (CONNECT tunnel); empty uses the standard proxy environment variables")
+		probePath     = flag.String("probe-path", envOrDefault("PROBE_PATH", "/probe"), "path of the blackbox-style probe endpoint (only with --probe-allowed-targets)")
+		probeAllowed  = flag.String("probe-allowed-targets", envOrDefault("PROBE_ALLOWED_TARGETS", ""), "comma-separated CIDRs and hostnames (*.example.lan for subdomains) that /probe may scrape; empty disables /probe")
+		httpHeaders   = flag.String("http-headers", envOrDefault("HTTP_HEADERS", ""), "comma-separated \"Name: value\" headers sent with every request to nasne (e.g. for an authenticating reverse proxy)")
+		boxNameLabel  = flag.Bool("box-name-label", envBool("BOX_NAME_LABEL", false), "add the device's own name (status/boxNameGet) as box_name label to every nasne series")
+		goMetrics     = flag.Bool("go-metrics", envBool("GO_METRICS", false), "export Go runtime metrics (go_*) of the exporter itself")
+		procMetrics   = flag.Bool("process-metrics", envBool("PROCESS_METRICS", false), "export process metrics (process_*) of the exporter itself")
+		showVersion   = flag.Bool("version", false, "print version information and exit")
 	)
+	collectorFlags := registerCollectorFlags()
 	flag.Parse()
 
-	nasneURLs := splitCSV(*nasneURLCSV)
-	if len(nasneURLs) == 0 {
-		log.Fatal("nasne-url (or NASNE_URL) is required")
+	build := readBuildInfo()
+	if *showVersion {
+		fmt.Println(build)
+		return
 	}
 
-	targets := make([]exporter.TargetFetcher, 0, len(nasneURLs))
-	for _, rawURL := range nasneURLs {
-		client, err := nasne.NewClient(rawURL, *httpTimeout)
+	var config *configFile
+	if *configPath != "" {
+		var err error
+		if config, err = loadConfig(*configPath); err != nil {
+			log.Fatalf("load config file: %v", err)
+		}
+		if err := config.applyGlobal(flag.CommandLine); err != nil {
+			log.Fatalf("config file %s: %v", *configPath, err)
+		}
+	}
+
+	enabled := enabledCollectors(collectorFlags)
+	if len(enabled) == 0 {
+		log.Fatal("at least one collector must be enabled")
+	}
+
+	probeAllowlist, err := parseAllowlist(splitCSV(*probeAllowed))
+	if err != nil {
+		log.Fatalf("invalid probe-allowed-targets: %v", err)
+	}
+
+	retryStatusCodes, err := parseStatusCodes(*retryStatus)
+	if err != nil {
+		log.Fatalf("invalid retry-status-codes: %v", err)
+	}
+	retryPolicy := nasne.RetryPolicy{
+		MaxAttempts:     *retryAttempts,
+		InitialBackoff:  *retryInitial,
+		MaxBackoff:      *retryMax,
+		RetryableStatus: retryStatusCodes,
+		Budget:          *retryBudget,
+	}
+
+	targetDefaults := targetConfig{
+		Transport: nasne.TransportConfig{
+			CAFile:              *caFile,
+			InsecureSkipVerify:  *tlsInsecure,
+			DisableKeepAlives:   *noKeepAlive,
+			TCPKeepAlive:        *tcpKeepAlive,
+			MaxIdleConns:        *maxIdle,
+			MaxIdleConnsPerHost: *maxIdlePer,
+			IdleConnTimeout:     *idleTimeout,
+			SourceAddress:       *sourceAddress,
+			Interface:           *sourceIface,
+			Proxy:               *proxyURL,
+		},
+		UserAgent:     *userAgent,
+		Headers:       splitHeaders(*httpHeaders),
+		HTTPTimeout:   *httpTimeout,
+		ScrapeTimeout: *scrapeTimeout,
+		Collectors:    enabled,
+	}
+
+	// Targets come from --nasne-url, or from the config file unless
+	// --nasne-url or NASNE_URL is given.
+	var targetConfigs []targetConfig
+	if config != nil && len(config.Targets) > 0 && *nasneURLCSV == "" {
+		if targetConfigs, err = config.targets(targetDefaults); err != nil {
+			log.Fatalf("config file %s: %v", *configPath, err)
+		}
+	}
+	for _, rawURL := range splitTargets(*nasneURLCSV) {
+		target, err := parseTarget(rawURL, targetDefaults)
+		if err != nil {
+			log.Fatalf("invalid nasne-url: %v", err)
+		}
+		targetConfigs = append(targetConfigs, target)
+	}
+	if len(targetConfigs) == 0 && probeAllowlist.empty() {
+		log.Fatal("nasne-url (or NASNE_URL, or targets in the config file) is required unless probe-allowed-targets is set")
+	}
+
+	// newClient creates a client for target with the global request settings;
+	// extra options are applied last.
+	newClient := func(target targetConfig, extra ...nasne.Option) (*nasne.Client, error) {
+		opts, err := target.clientOptions()
 		if err != nil {
-			log.Fatalf("create nasne client for %s: %v", rawURL, err)
+			return nil, err
+		}
+		opts = append(opts,
+			nasne.WithSections(target.Collectors...),
+			nasne.WithPageSize(*listPageSize),
+			nasne.WithMaxConcurrency(*concurrency),
+			nasne.WithRetryPolicy(retryPolicy),
+		)
+		if *schemaCheck {
+			opts = append(opts, nasne.WithSchemaCheck())
 		}
-		targets = append(targets, exporter.TargetFetcher{Target: safeTargetLabel(rawURL), Fetcher: client})
+		return nasne.NewClient(target.URL, target.HTTPTimeout, append(opts, extra...)...)
 	}
 
-	collector := exporter.NewCollector(targets, *scrapeTimeout)
+	targetLabels := make([]map[string]string, 0, len(targetConfigs))
+	for _, target := range targetConfigs {
+		targetLabels = append(targetLabels, target.Labels)
+	}
+	requestMetrics := exporter.NewRequestMetrics(exporter.StaticLabelNames(targetLabels...)...)
+	targets := make([]exporter.TargetFetcher, 0, len(targetConfigs))
+	clients := map[string]*nasne.Client{}
+	var pollers []*exporter.Poller
+	var targetCollectors []string
+	for _, target := range targetConfigs {
+		label := target.label()
+		if _, ok := clients[label]; ok {
+			log.Fatalf("duplicate target %q: use alias= to tell the nasne-url entries apart", label)
+		}
+		client, err := newClient(target, nasne.WithRequestObserver(requestMetrics.Observer(label, target.Labels)))
+		if err != nil {
+			log.Fatalf("create nasne client for %s: %v", label, err)
+		}
+		clients[label] = client
+		var fetcher exporter.Fetcher = client
+		if *breakerFails > 0 {
+			fetcher = exporter.NewBreaker(client, *breakerFails, *breakerOpen)
+		}
+		if *pollInterval > 0 {
+			p := exporter.NewPoller(fetcher, *pollInterval, *pollJitter, target.ScrapeTimeout, *maxStaleness)
+			pollers = append(pollers, p)
+			fetcher = p
+		}
+		targets = append(targets, exporter.TargetFetcher{Target: label, Fetcher: fetcher, Labels: target.Labels, Timeout: target.ScrapeTimeout})
+		for _, name := range target.Collectors {
+			if !slices.Contains(targetCollectors, name) {
+				targetCollectors = append(targetCollectors, name)
+			}
+		}
+	}
+
+	var collectorOpts []exporter.Option
+	if *dtcpipInfo {
+		collectorOpts = append(collectorOpts, exporter.WithDTCPIPClientInfo(*dtcpipLimit))
+	}
+	if *boxNameLabel {
+		collectorOpts = append(collectorOpts, exporter.WithBoxNameLabel())
+	}
+
+	for _, p := range pollers {
+		go p.Run(context.Background())
+	}
+
+	collector := exporter.NewCollector(targets, *scrapeTimeout, append(slices.Clip(collectorOpts), exporter.WithSubCollectors(targetCollectors...))...)
 	registry := prometheus.NewRegistry()
-	registry.MustRegister(collector)
+	for _, c := range []prometheus.Collector{collector, requestMetrics} {
+		if err := registry.Register(c); err != nil {
+			log.Fatalf("invalid target labels: %v", err)
+		}
+	}
+	registry.MustRegister(build.collector())
+	if *goMetrics {
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+	if *procMetrics {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
 
 	mux := http.NewServeMux()
 	mux.Handle(*metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
@@ -56,6 +241,30 @@ func main() {
 		}
 		_, _ = w.Write([]byte("ok\n"))
 	})
+	if !probeAllowlist.empty() {
+		mux.Handle(*probePath, &prober{
+			allow:         probeAllowlist,
+			timeout:       *scrapeTimeout,
+			collectorOpts: append(slices.Clip(collectorOpts), exporter.WithSubCollectors(enabled...)),
+			newClient: func(rawURL string, opts ...nasne.Option) (*nasne.Client, error) {
+				target := targetDefaults
+				target.URL = rawURL
+				return newClient(target, opts...)
+			},
+		})
+	}
+	if *schemaCheck {
+		mux.HandleFunc(*schemaPath, func(w http.ResponseWriter, _ *http.Request) {
+			reports := map[string][]nasne.SchemaReport{}
+			for label, client := range clients {
+				reports[label] = client.SchemaReports()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(reports)
+		})
+	}
 	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
 		_, _ = w.Write([]byte("nasne_exporter\n"))
 	})
@@ -66,15 +275,69 @@ func main() {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	log.Printf("starting nasne_exporter on %s", *listenAddress)
+	log.Printf("starting %s on %s", build, *listenAddress)
 	log.Printf("metrics endpoint: %s", *metricsPath)
 	log.Printf("health endpoint: %s", *healthPath)
-	log.Printf("targets: %d", len(nasneURLs))
+	if !probeAllowlist.empty() {
+		log.Printf("probe endpoint: %s", *probePath)
+	}
+	if *schemaCheck {
+		log.Printf("schema debug endpoint: %s", *schemaPath)
+	}
+	log.Printf("targets: %d", len(targets))
+	log.Printf("enabled collectors: %s", strings.Join(enabled, ", "))
+	if *pollInterval > 0 {
+		log.Printf("polling every %s (jitter %s, max staleness %s)", *pollInterval, *pollJitter, *maxStaleness)
+	}
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("http server failed: %v", err)
 	}
 }
 
+// collectorDescriptions documents the sub-collectors in flag help texts.
+var collectorDescriptions = map[string]string{
+	nasne.SectionDevice:       "device name and versions",
+	nasne.SectionStorage:      "HDD capacity and usage",
+	nasne.SectionTuner:        "tuner state and current channel",
+	nasne.SectionDTCPIP:       "connected DTCP-IP clients",
+	nasne.SectionRecorded:     "recorded library; pages through recorded/titleListGet, the most expensive call",
+	nasne.SectionReservations: "recording reservations from schedule/reservedListGet",
+}
+
+// collectorFlag holds the --collector.<name> and --no-collector.<name> flags
+// of one sub-collector.
+type collectorFlag struct {
+	name    string
+	enable  *bool
+	disable *bool
+}
+
+// registerCollectorFlags defines node_exporter-style flags for every
+// sub-collector. All collectors are enabled by default; COLLECTOR_<NAME>
+// environment variables set the default.
+func registerCollectorFlags() []collectorFlag {
+	var flags []collectorFlag
+	for _, name := range exporter.SubCollectorNames() {
+		desc := collectorDescriptions[name]
+		flags = append(flags, collectorFlag{
+			name:    name,
+			enable:  flag.Bool("collector."+name, envBool("COLLECTOR_"+strings.ToUpper(name), true), "enable the "+name+" collector: "+desc),
+			disable: flag.Bool("no-collector."+name, false, "disable the "+name+" collector"),
+		})
+	}
+	return flags
+}
+
+func enabledCollectors(flags []collectorFlag) []string {
+	var names []string
+	for _, f := range flags {
+		if *f.enable && !*f.disable {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
 func splitCSV(s string) []string {
 	parts := strings.Split(s, ",")
 	out := make([]string, 0, len(parts))
@@ -87,6 +350,58 @@ func splitCSV(s string) []string {
 	return out
 }
 
+var (
+	targetStart = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://`)
+	headerStart = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+:")
+)
+
+// splitTargets splits a comma-separated --nasne-url list. Only a comma
+// followed by scheme:// starts a new entry, so query parameter values such
+// as collectors=device,storage may contain commas.
+func splitTargets(s string) []string {
+	return splitEntries(s, targetStart)
+}
+
+// splitHeaders splits a comma-separated list of "Name: value" headers. Only
+// a comma followed by a header name and a colon starts a new header, so
+// values may contain commas.
+func splitHeaders(s string) []string {
+	return splitEntries(s, headerStart)
+}
+
+// splitEntries is splitCSV, except that parts not matching start are joined
+// back to the previous entry. Empty parts are dropped.
+func splitEntries(s string, start *regexp.Regexp) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		trimmed := strings.TrimSpace(p)
+		switch {
+		case trimmed == "":
+		case len(out) > 0 && !start.MatchString(trimmed):
+			out[len(out)-1] += "," + p
+		default:
+			out = append(out, trimmed)
+		}
+	}
+	for i := range out {
+		out[i] = strings.TrimSpace(out[i])
+	}
+	return out
+}
+
+func parseStatusCodes(s string) ([]int, error) {
+	parts := splitCSV(s)
+	codes := make([]int, 0, len(parts))
+	for _, p := range parts {
+		code, err := strconv.Atoi(p)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %q", p)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
 func safeTargetLabel(rawURL string) string {
 	u, err := url.Parse(rawURL)
 	if err != nil {
@@ -104,7 +419,7 @@ func safeTargetLabel(rawURL string) string {
 			port = "80"
 		}
 	}
-	return net.JoinHostPort(host, port)
+	return net.JoinHostPort(host, port) + strings.TrimRight(u.Path, "/")
 }
 
 func envOrDefault(k, d string) string {
@@ -126,3 +441,29 @@ func envDuration(k string, d time.Duration) time.Duration {
 	}
 	return parsed
 }
+
+func envInt(k string, d int) int {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return d
+	}
+	parsed, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid integer in %s=%q: %v (using default %d)", k, v, err, d)
+		return d
+	}
+	return parsed
+}
+
+func envBool(k string, d bool) bool {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return d
+	}
+	parsed, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid boolean in %s=%q: %v (using default %t)", k, v, err, d)
+		return d
+	}
+	return parsed
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/exporter"
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// prober serves /probe?target=host:port in the style of blackbox_exporter:
+// every request scrapes one allowlisted nasne with a fresh client and
+// collector and returns only that target's metrics.
+type prober struct {
+	allow         *allowlist
+	timeout       time.Duration
+	collectorOpts []exporter.Option
+	newClient     func(rawURL string, opts ...nasne.Option) (*nasne.Client, error)
+}
+
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	timeout := p.probeTimeout(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	rawURL, label, err := p.allow.pin(ctx, target)
+	if err != nil {
+		log.Printf("warn: probe rejected for target=%s err=%v", target, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	requestMetrics := exporter.NewRequestMetrics()
+	client, err := p.newClient(rawURL, nasne.WithRequestObserver(requestMetrics.Observer(label, nil)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer client.CloseIdleConnections()
+
+	fetcher := requestFetcher{ctx: ctx, fetcher: client}
+	collector := exporter.NewCollector([]exporter.TargetFetcher{{Target: label, Fetcher: fetcher}}, timeout, p.collectorOpts...)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	// Gatherers run in order, so the request metrics are gathered after the
+	// collector has scraped the device.
+	requestRegistry := prometheus.NewRegistry()
+	requestRegistry.MustRegister(requestMetrics)
+	promhttp.HandlerFor(prometheus.Gatherers{registry, requestRegistry}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// scrapeTimeoutMargin is kept free of the scrape timeout Prometheus announces
+// so that the probe can still answer in time, like blackbox_exporter's
+// --timeout-offset.
+const scrapeTimeoutMargin = 500 * time.Millisecond
+
+// probeTimeout returns the time a probe may take: the scrape timeout from
+// X-Prometheus-Scrape-Timeout-Seconds less scrapeTimeoutMargin, capped at
+// p.timeout.
+func (p *prober) probeTimeout(r *http.Request) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return p.timeout
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutMargin {
+		timeout -= scrapeTimeoutMargin
+	}
+	return min(timeout, p.timeout)
+}
+
+// requestFetcher fetches with the context of the probe request as well, so
+// that the device is no longer queried once Prometheus has given up.
+type requestFetcher struct {
+	ctx     context.Context
+	fetcher exporter.Fetcher
+}
+
+func (f requestFetcher) FetchSnapshot(ctx context.Context) (nasne.Snapshot, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(f.ctx, cancel)
+	defer stop()
+	return f.fetcher.FetchSnapshot(ctx)
+}
+
+// Unwrap returns the wrapped fetcher.
+func (f requestFetcher) Unwrap() exporter.Fetcher {
+	return f.fetcher
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/exporter"
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+var errUnknownParameter = errors.New("unknown target parameter")
+
+// targetConfig is one --nasne-url entry together with its per-target settings.
+type targetConfig struct {
+	URL         string
+	RecordedURL string
+	ScheduleURL string
+	Transport   nasne.TransportConfig
+	UserAgent   string
+
+	HTTPTimeout   time.Duration
+	ScrapeTimeout time.Duration
+	// Collectors are the sub-collectors whose endpoints are fetched.
+	Collectors []string
+
+	// Username and PasswordFile are basic auth credentials for a reverse
+	// proxy in front of the device.
+	Username     string
+	PasswordFile string
+	// Headers are extra request headers as "Name: value" pairs.
+	Headers []string
+
+	// Alias replaces the host:port target label.
+	Alias string
+	// Labels are static labels added to every series of the target.
+	Labels map[string]string
+}
+
+// parseTarget splits a --nasne-url entry into the base URL and the settings
+// given as query parameters, e.g.
+// http://192.168.20.5:64210?interface=eth0.20&user_agent=probe. Parameters
+// override the corresponding fields of defaults; header.<Name> and
+// label.<name> parameters are added to the default headers and labels.
+func parseTarget(raw string, defaults targetConfig) (targetConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return targetConfig{}, fmt.Errorf("parse target: %w", redactURLError(err))
+	}
+	cfg := defaults
+	query := u.Query()
+	u.RawQuery = ""
+	cfg.URL = u.String()
+
+	for key, values := range query {
+		v := values[len(values)-1]
+		if err := cfg.set(key, v); err != nil {
+			return targetConfig{}, fmt.Errorf("target %s: %s=%q: %w", u.Redacted(), key, v, err)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *targetConfig) set(key, v string) error {
+	t := &cfg.Transport
+	var err error
+	switch key {
+	case "ca_file":
+		t.CAFile = v
+	case "insecure_skip_verify":
+		t.InsecureSkipVerify, err = strconv.ParseBool(v)
+	case "disable_keepalives":
+		t.DisableKeepAlives, err = strconv.ParseBool(v)
+	case "tcp_keepalive":
+		t.TCPKeepAlive, err = time.ParseDuration(v)
+	case "max_idle_conns":
+		t.MaxIdleConns, err = strconv.Atoi(v)
+	case "max_idle_conns_per_host":
+		t.MaxIdleConnsPerHost, err = strconv.Atoi(v)
+	case "idle_conn_timeout":
+		t.IdleConnTimeout, err = time.ParseDuration(v)
+	case "proxy":
+		t.Proxy = v
+	case "source_address":
+		t.SourceAddress, t.Interface = v, ""
+	case "interface":
+		t.Interface, t.SourceAddress = v, ""
+	case "user_agent":
+		cfg.UserAgent = v
+	case "recorded_url":
+		cfg.RecordedURL = v
+	case "schedule_url":
+		cfg.ScheduleURL = v
+	case "username":
+		cfg.Username = v
+	case "password_file":
+		cfg.PasswordFile = v
+	case "alias":
+		cfg.Alias = v
+	case "http_timeout":
+		cfg.HTTPTimeout, err = time.ParseDuration(v)
+	case "scrape_timeout":
+		cfg.ScrapeTimeout, err = time.ParseDuration(v)
+	case "collectors":
+		names := splitCSV(v)
+		if len(names) == 0 {
+			return fmt.Errorf("at least one collector must be enabled")
+		}
+		err = exporter.ValidateSubCollectors(names)
+		cfg.Collectors = names
+	default:
+		if name, ok := strings.CutPrefix(key, "label."); ok && name != "" {
+			if err := exporter.ValidateStaticLabel(name); err != nil {
+				return err
+			}
+			cfg.Labels = maps.Clone(cfg.Labels)
+			if cfg.Labels == nil {
+				cfg.Labels = map[string]string{}
+			}
+			cfg.Labels[name] = v
+			return nil
+		}
+		name, ok := strings.CutPrefix(key, "header.")
+		if !ok || name == "" {
+			return errUnknownParameter
+		}
+		cfg.Headers = append(slices.Clip(cfg.Headers), name+": "+v)
+	}
+	return err
+}
+
+// redactURLError removes the password from the URL quoted in a url.Parse
+// error. The URL could not be parsed, so everything between the scheme and
+// the last "@" is dropped.
+func redactURLError(err error) error {
+	var uerr *url.Error
+	if !errors.As(err, &uerr) {
+		return err
+	}
+	redacted := *uerr
+	if scheme, rest, ok := strings.Cut(uerr.URL, "://"); ok {
+		if i := strings.LastIndex(rest, "@"); i >= 0 {
+			redacted.URL = scheme + "://xxxxx@" + rest[i+1:]
+		}
+	}
+	return &redacted
+}
+
+// label returns the value of the target label: the alias if set, otherwise
+// the host and port of the URL.
+func (cfg targetConfig) label() string {
+	if cfg.Alias != "" {
+		return cfg.Alias
+	}
+	return safeTargetLabel(cfg.URL)
+}
+
+// clientOptions returns the nasne client options for the URLs and
+// credentials of cfg.
+func (cfg targetConfig) clientOptions() ([]nasne.Option, error) {
+	opts := []nasne.Option{
+		nasne.WithTransport(cfg.Transport),
+		nasne.WithUserAgent(cfg.UserAgent),
+	}
+	if cfg.RecordedURL != "" {
+		opts = append(opts, nasne.WithGroupBaseURL(nasne.GroupRecorded, cfg.RecordedURL))
+	}
+	if cfg.ScheduleURL != "" {
+		opts = append(opts, nasne.WithGroupBaseURL(nasne.GroupSchedule, cfg.ScheduleURL))
+	}
+	if cfg.Username != "" {
+		var password string
+		if cfg.PasswordFile != "" {
+			b, err := os.ReadFile(cfg.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("read password file: %w", err)
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		opts = append(opts, nasne.WithBasicAuth(cfg.Username, password))
+	}
+	for _, h := range cfg.Headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, want \"Name: value\"", h)
+		}
+		opts = append(opts, nasne.WithHeader(strings.TrimSpace(name), strings.TrimSpace(value)))
+	}
+	return opts, nil
+}
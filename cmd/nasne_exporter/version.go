@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time, e.g.
+// go build -ldflags "-X main.version=v0.2.0 -X main.revision=$(git rev-parse HEAD)".
+// Empty values fall back to the module and VCS information embedded by the
+// Go toolchain.
+var (
+	version  string
+	revision string
+)
+
+type buildInfo struct {
+	Version   string
+	Revision  string
+	GoVersion string
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Revision: revision, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" && info.Revision == "" {
+				info.Revision = s.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "unknown"
+	}
+	if info.Revision == "" {
+		info.Revision = "unknown"
+	}
+	return info
+}
+
+func (b buildInfo) String() string {
+	return fmt.Sprintf("nasne_exporter version %s (revision %s, %s)", b.Version, b.Revision, b.GoVersion)
+}
+
+// collector returns the constant nasne_exporter_build_info gauge.
+func (b buildInfo) collector() prometheus.Collector {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "nasne_exporter_build_info",
+		Help:        "A metric with a constant '1' value labeled by version, revision and Go version of the exporter.",
+		ConstLabels: prometheus.Labels{"version": b.Version, "revision": b.Revision, "goversion": b.GoVersion},
+	})
+	g.Set(1)
+	return g
+}
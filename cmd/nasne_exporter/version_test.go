@@ -0,0 +1,47 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBuildInfo(t *testing.T) {
+	oldVersion, oldRevision := version, revision
+	t.Cleanup(func() { version, revision = oldVersion, oldRevision })
+	version, revision = "v1.2.3", "abc123"
+
+	info := readBuildInfo()
+	want := buildInfo{Version: "v1.2.3", Revision: "abc123", GoVersion: runtime.Version()}
+	if info != want {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+	if s := info.String(); !strings.Contains(s, "v1.2.3") || !strings.Contains(s, "abc123") {
+		t.Fatalf("unexpected version string %q", s)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(info.collector())
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	if len(mfs) != 1 || mfs[0].GetName() != "nasne_exporter_build_info" {
+		t.Fatalf("unexpected metric families: %v", mfs)
+	}
+	m := mfs[0].GetMetric()[0]
+	labels := map[string]string{}
+	for _, lp := range m.GetLabel() {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	if m.GetGauge().GetValue() != 1 || labels["version"] != "v1.2.3" || labels["revision"] != "abc123" || labels["goversion"] != runtime.Version() {
+		t.Fatalf("unexpected build info metric: %v", m)
+	}
+
+	version, revision = "", ""
+	if info := readBuildInfo(); info.Version == "" || info.Revision == "" {
+		t.Fatalf("fallback left empty fields: %+v", info)
+	}
+}
@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// ErrCircuitOpen is returned by a Breaker while it fails fast.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// BreakerState is the state of a Breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+var breakerStates = []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// Breaker is a per-device circuit breaker around a Fetcher. After threshold
+// consecutive scrapes in which the device could not be reached it opens and
+// fails fast with ErrCircuitOpen. Once openFor has passed, a single scrape is
+// let through as a probe: success closes the breaker, failure re-opens it.
+// Partial scrapes count as successes since the device did answer.
+type Breaker struct {
+	fetcher   Fetcher
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewBreaker(fetcher Fetcher, threshold int, openFor time.Duration) *Breaker {
+	return &Breaker{
+		fetcher:   fetcher,
+		threshold: threshold,
+		openFor:   openFor,
+		now:       time.Now,
+	}
+}
+
+func (b *Breaker) FetchSnapshot(ctx context.Context) (nasne.Snapshot, error) {
+	if !b.allow() {
+		return nasne.Snapshot{}, ErrCircuitOpen
+	}
+	snapshot, err := b.fetcher.FetchSnapshot(ctx)
+	b.record(err == nil || snapshot.Reachable())
+	return snapshot, err
+}
+
+// BreakerState returns the current state of the breaker.
+func (b *Breaker) BreakerState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.openFor {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// A probe is already in flight.
+		return false
+	}
+	return true
+}
+
+func (b *Breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,87 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// countingFetcher counts calls and fails while err is set.
+type countingFetcher struct {
+	calls int
+	err   error
+}
+
+func (f *countingFetcher) FetchSnapshot(_ context.Context) (nasne.Snapshot, error) {
+	f.calls++
+	return nasne.Snapshot{}, f.err
+}
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &countingFetcher{err: errors.New("unreachable")}
+	b := NewBreaker(f, 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.FetchSnapshot(context.Background()); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("breaker opened too early on attempt %d", i+1)
+		}
+	}
+	if b.BreakerState() != BreakerOpen {
+		t.Fatalf("unexpected state after failures: %s", b.BreakerState())
+	}
+
+	if _, err := b.FetchSnapshot(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker should fail fast, got %v", err)
+	}
+	if f.calls != 2 {
+		t.Fatalf("open breaker should not call the device: calls=%d", f.calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := b.FetchSnapshot(context.Background()); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("breaker should let a probe through after the open duration")
+	}
+	if b.BreakerState() != BreakerOpen {
+		t.Fatalf("failed probe should re-open the breaker: %s", b.BreakerState())
+	}
+
+	now = now.Add(2 * time.Minute)
+	f.err = nil
+	if _, err := b.FetchSnapshot(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if b.BreakerState() != BreakerClosed {
+		t.Fatalf("successful probe should close the breaker: %s", b.BreakerState())
+	}
+}
+
+func TestCollectorCircuitOpen(t *testing.T) {
+	b := NewBreaker(&countingFetcher{err: errors.New("unreachable")}, 1, time.Hour)
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: b}}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Gather(); err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	if v, ok := gaugeValue(mfs, "nasne_up", nil); !ok || v != 0 {
+		t.Fatalf("unexpected up while circuit is open: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_circuit_breaker_state", map[string]string{"state": "open"}); !ok || v != 1 {
+		t.Fatalf("unexpected breaker state: %v (found=%v)", v, ok)
+	}
+}
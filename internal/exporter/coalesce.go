@@ -0,0 +1,55 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// flight is an in-progress FetchSnapshot call shared by concurrent scrapes.
+type flight struct {
+	done     chan struct{}
+	snapshot nasne.Snapshot
+	err      error
+}
+
+// fetch returns a snapshot for t. Concurrent calls for the same target, for
+// example from HA Prometheus replicas scraping at the same moment, share a
+// single in-flight FetchSnapshot instead of hitting the device again.
+func (c *Collector) fetch(t TargetFetcher) (nasne.Snapshot, error) {
+	c.flightsMu.Lock()
+	if f, ok := c.flights[t.Target]; ok {
+		c.coalesced[t.Target]++
+		c.flightsMu.Unlock()
+		<-f.done
+		return f.snapshot, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[t.Target] = f
+	c.flightsMu.Unlock()
+
+	timeout := c.timeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	f.snapshot, f.err = t.Fetcher.FetchSnapshot(ctx)
+	cancel()
+
+	c.flightsMu.Lock()
+	delete(c.flights, t.Target)
+	c.flightsMu.Unlock()
+	close(f.done)
+	return f.snapshot, f.err
+}
+
+// coalescedCounts returns a copy of the coalesced fetch counters.
+func (c *Collector) coalescedCounts() map[string]float64 {
+	c.flightsMu.Lock()
+	defer c.flightsMu.Unlock()
+	counts := make(map[string]float64, len(c.coalesced))
+	for target, n := range c.coalesced {
+		counts[target] = n
+	}
+	return counts
+}
@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// blockingFetcher blocks every FetchSnapshot until release is closed.
+type blockingFetcher struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingFetcher) FetchSnapshot(_ context.Context) (nasne.Snapshot, error) {
+	if f.calls.Add(1) == 1 {
+		close(f.started)
+	}
+	<-f.release
+	return nasne.Snapshot{Name: "nasne-a"}, nil
+}
+
+func TestCollectorCoalescesConcurrentScrapes(t *testing.T) {
+	const target = "192.168.11.1:64210"
+	f := &blockingFetcher{started: make(chan struct{}), release: make(chan struct{})}
+	c := NewCollector([]TargetFetcher{{Target: target, Fetcher: f}}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	var wg sync.WaitGroup
+	gather := func() {
+		defer wg.Done()
+		if _, err := r.Gather(); err != nil {
+			t.Errorf("gather failed: %v", err)
+		}
+	}
+	wg.Add(2)
+	go gather()
+	<-f.started
+	go gather()
+
+	deadline := time.Now().Add(time.Second)
+	for c.coalescedCounts()[target] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("second scrape did not join the in-flight fetch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(f.release)
+	wg.Wait()
+
+	if got := f.calls.Load(); got != 1 {
+		t.Fatalf("device fetched %d times, want 1", got)
+	}
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_coalesced_fetches_total", map[string]string{"target": target}); !ok || v != 1 {
+		t.Fatalf("unexpected nasne_coalesced_fetches_total: %v (found %t)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_scrapes_total", map[string]string{"target": target, "result": "success"}); !ok || v != 3 {
+		t.Fatalf("unexpected nasne_scrapes_total: %v (found %t)", v, ok)
+	}
+}
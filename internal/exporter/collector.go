@@ -2,7 +2,14 @@ package exporter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,70 +22,162 @@ type Fetcher interface {
 	FetchSnapshot(ctx context.Context) (nasne.Snapshot, error)
 }
 
+// breakerStater is implemented by fetchers wrapped in a circuit breaker.
+type breakerStater interface {
+	BreakerState() BreakerState
+}
+
 type TargetFetcher struct {
 	Target  string
 	Fetcher Fetcher
+	// Labels are static labels added to every series of the target, such as
+	// room="living". Targets without a label that another target has get
+	// an empty value for it.
+	Labels map[string]string
+	// Timeout bounds each fetch of the target; 0 uses the collector's
+	// timeout.
+	Timeout time.Duration
 }
 
 type collectResult struct {
 	target   string
+	labels   []string
 	snapshot nasne.Snapshot
 	err      error
 	duration float64
+	breaker  *BreakerState
+	cache    *cacheState
+}
+
+type cacheState struct {
+	fetchedAt, lastSuccess time.Time
 }
 
 type Collector struct {
 	targets []TargetFetcher
 	timeout time.Duration
+	now     func() time.Time
+
+	dtcpipClientInfoLimit int
+	enabled               []string
+	subs                  []SubCollector
+
+	// targetLabels are the names of the labels every series starts with:
+	// target, the static labels of all targets and optionally box_name.
+	targetLabels []string
+	staticLabels []string
+	boxNameLabel bool
+
+	// counterLabels leave out box_name, which changes when the device is
+	// renamed or first answers, so that each counter keeps a single series.
+	counterLabels []string
 
 	mu               sync.RWMutex
+	boxNames         map[string]string   // last known box name per target
+	counterValues    map[string][]string // counterLabels values per target
 	lastScrapeErrors map[string]error
 	hasScrapedOnce   bool
+	scrapes          map[[2]string]float64 // {target, result}
+	scrapeErrors     map[[2]string]float64 // {target, reason}
+
+	flightsMu sync.Mutex
+	flights   map[string]*flight
+	coalesced map[string]float64
+
+	collectDuration *prometheus.Desc
+	up              *prometheus.Desc
+	endpointUp      *prometheus.Desc
+	breakerState    *prometheus.Desc
+	scrapesTotal    *prometheus.Desc
+	scrapeErrorsTot *prometheus.Desc
+	coalescedTotal  *prometheus.Desc
+	lastSuccess     *prometheus.Desc
+	snapshotAge     *prometheus.Desc
+	portInfo        *prometheus.Desc
+	schemaFields    *prometheus.Desc
+}
+
+// Option configures optional Collector behaviour.
+type Option func(*Collector)
+
+// WithBoxNameLabel adds a box_name label with the device's own name
+// (status/boxNameGet) to every series. The last known name is kept while the
+// device is unreachable; it is empty until the name was fetched once.
+func WithBoxNameLabel() Option {
+	return func(c *Collector) {
+		c.boxNameLabel = true
+	}
+}
 
-	collectDuration  *prometheus.Desc
-	up               *prometheus.Desc
-	info             *prometheus.Desc
-	hddSizeBytes     *prometheus.Desc
-	hddUsageBytes    *prometheus.Desc
-	dtcpipClients    *prometheus.Desc
-	recordings       *prometheus.Desc
-	recordedTitles   *prometheus.Desc
-	reservedTitles   *prometheus.Desc
-	reservedConflict *prometheus.Desc
-	reservedNotFound *prometheus.Desc
-}
-
-func NewCollector(targets []TargetFetcher, timeout time.Duration) *Collector {
-	return &Collector{
-		targets:           targets,
-		timeout:           timeout,
-		lastScrapeErrors:  map[string]error{},
-		collectDuration:   prometheus.NewDesc("nasne_collect_duration_seconds", "Time spent collecting metrics from nasne.", []string{"target"}, nil),
-		up:                prometheus.NewDesc("nasne_up", "Whether the last scrape from nasne succeeded.", []string{"target"}, nil),
-		info:              prometheus.NewDesc("nasne_info", "nasne device information.", []string{"target", "name", "product_name", "hardware_version", "software_version"}, nil),
-		hddSizeBytes:      prometheus.NewDesc("nasne_hdd_size_bytes", "Total HDD size in bytes.", []string{"target"}, nil),
-		hddUsageBytes:     prometheus.NewDesc("nasne_hdd_usage_bytes", "Used HDD size in bytes.", []string{"target"}, nil),
-		dtcpipClients:     prometheus.NewDesc("nasne_dtcpip_clients", "Connected DTCP-IP clients.", []string{"target"}, nil),
-		recordings:        prometheus.NewDesc("nasne_recordings", "Number of current recordings.", []string{"target"}, nil),
-		recordedTitles:    prometheus.NewDesc("nasne_recorded_titles", "Number of recorded titles.", []string{"target"}, nil),
-		reservedTitles:    prometheus.NewDesc("nasne_reserved_titles", "Number of reserved titles.", []string{"target"}, nil),
-		reservedConflict:  prometheus.NewDesc("nasne_reserved_conflict_titles", "Number of conflicting reserved titles.", []string{"target"}, nil),
-		reservedNotFound:  prometheus.NewDesc("nasne_reserved_notfound_titles", "Number of not-found reserved titles.", []string{"target"}, nil),
+// WithDTCPIPClientInfo enables nasne_dtcpip_client_info, exporting at most
+// limit connected clients per target to keep cardinality bounded.
+func WithDTCPIPClientInfo(limit int) Option {
+	return func(c *Collector) {
+		c.dtcpipClientInfoLimit = limit
 	}
 }
 
+func NewCollector(targets []TargetFetcher, timeout time.Duration, opts ...Option) *Collector {
+	c := &Collector{
+		targets:          targets,
+		timeout:          timeout,
+		now:              time.Now,
+		lastScrapeErrors: map[string]error{},
+		scrapes:          map[[2]string]float64{},
+		scrapeErrors:     map[[2]string]float64{},
+		flights:          map[string]*flight{},
+		coalesced:        map[string]float64{},
+		boxNames:         map[string]string{},
+		counterValues:    map[string][]string{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	labels := make([]map[string]string, 0, len(targets))
+	for _, t := range targets {
+		labels = append(labels, t.Labels)
+	}
+	c.staticLabels = StaticLabelNames(labels...)
+	c.counterLabels = append([]string{"target"}, c.staticLabels...)
+	c.targetLabels = c.counterLabels
+	if c.boxNameLabel {
+		c.targetLabels = append(slices.Clip(c.counterLabels), "box_name")
+	}
+	c.collectDuration = newDesc(c.targetLabels, "nasne_collect_duration_seconds", "Time spent collecting metrics from nasne.")
+	c.up = newDesc(c.targetLabels, "nasne_up", "Whether nasne answered the last scrape; see nasne_endpoint_up for per-endpoint results.")
+	c.breakerState = newDesc(c.targetLabels, "nasne_circuit_breaker_state", "Circuit breaker state per target; the series for the current state is 1, all others 0.", "state")
+	c.scrapesTotal = newDesc(c.counterLabels, "nasne_scrapes_total", "Scrapes per target by result (success, partial, failure).", "result")
+	c.scrapeErrorsTot = newDesc(c.counterLabels, "nasne_scrape_errors_total", "Failed scrapes per target by error reason.", "reason")
+	c.coalescedTotal = newDesc(c.counterLabels, "nasne_coalesced_fetches_total", "Scrapes that shared an in-flight fetch of the same target instead of querying the device again.")
+	c.lastSuccess = newDesc(c.targetLabels, "nasne_last_success_timestamp_seconds", "Unix time of the last poll in which every endpoint answered. Only in polling mode.")
+	c.snapshotAge = newDesc(c.targetLabels, "nasne_snapshot_age_seconds", "Age of the cached snapshot served for the target. Only in polling mode.")
+	c.portInfo = newDesc(c.targetLabels, "nasne_endpoint_port_info", "Port the exporter learned for each nasne endpoint group.", "group", "port")
+	c.schemaFields = newDesc(c.targetLabels, "nasne_schema_unexpected_fields", "Fields of the last response that differ from what the exporter decodes, by kind (unknown, missing). Only with schema checks enabled.", "endpoint", "kind")
+	c.endpointUp = newDesc(c.targetLabels, "nasne_endpoint_up", "Whether the last request to a nasne API endpoint succeeded.", "endpoint")
+	for _, name := range nasne.Sections {
+		if c.enabled != nil && !slices.Contains(c.enabled, name) {
+			continue
+		}
+		c.subs = append(c.subs, subCollectorFactories[name](c))
+	}
+	return c
+}
+
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.collectDuration
 	ch <- c.up
-	ch <- c.info
-	ch <- c.hddSizeBytes
-	ch <- c.hddUsageBytes
-	ch <- c.dtcpipClients
-	ch <- c.recordings
-	ch <- c.recordedTitles
-	ch <- c.reservedTitles
-	ch <- c.reservedConflict
-	ch <- c.reservedNotFound
+	ch <- c.endpointUp
+	ch <- c.breakerState
+	ch <- c.scrapesTotal
+	ch <- c.scrapeErrorsTot
+	ch <- c.coalescedTotal
+	ch <- c.lastSuccess
+	ch <- c.snapshotAge
+	ch <- c.portInfo
+	ch <- c.schemaFields
+	for _, sub := range c.subs {
+		sub.Describe(ch)
+	}
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
@@ -91,52 +190,287 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 		go func() {
 			defer wg.Done()
 			start := time.Now()
-			ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
-			snapshot, err := target.Fetcher.FetchSnapshot(ctx)
-			cancel()
-			results <- collectResult{
+			snapshot, err := c.fetch(target)
+			r := collectResult{
 				target:   target.Target,
+				labels:   c.labelValuesFor(target, snapshot),
 				snapshot: snapshot,
 				err:      err,
 				duration: time.Since(start).Seconds(),
 			}
+			if b, ok := findFetcher[breakerStater](target.Fetcher); ok {
+				state := b.BreakerState()
+				r.breaker = &state
+			}
+			if cs, ok := findFetcher[cacheStater](target.Fetcher); ok {
+				fetchedAt, lastSuccess := cs.CacheState()
+				r.cache = &cacheState{fetchedAt: fetchedAt, lastSuccess: lastSuccess}
+			}
+			results <- r
 		}()
 	}
 
 	wg.Wait()
 	close(results)
 
-	errors := map[string]error{}
+	now := c.now()
+	scrapeErrors := map[string]error{}
 	for r := range results {
-		ch <- prometheus.MustNewConstMetric(c.collectDuration, prometheus.GaugeValue, r.duration, r.target)
+		ch <- prometheus.MustNewConstMetric(c.collectDuration, prometheus.GaugeValue, r.duration, r.labels...)
 
 		if r.err != nil {
-			errors[r.target] = r.err
+			scrapeErrors[r.target] = r.err
+		}
+		for group, port := range r.snapshot.Ports {
+			ch <- prometheus.MustNewConstMetric(c.portInfo, prometheus.GaugeValue, 1, withLabels(r.labels, group, strconv.Itoa(port))...)
+		}
+		for _, report := range r.snapshot.SchemaReports {
+			ch <- prometheus.MustNewConstMetric(c.schemaFields, prometheus.GaugeValue, float64(report.UnknownCount), withLabels(r.labels, report.Endpoint, "unknown")...)
+			ch <- prometheus.MustNewConstMetric(c.schemaFields, prometheus.GaugeValue, float64(report.MissingCount), withLabels(r.labels, report.Endpoint, "missing")...)
+		}
+		for endpoint, ok := range r.snapshot.Endpoints {
+			ch <- prometheus.MustNewConstMetric(c.endpointUp, prometheus.GaugeValue, boolToFloat(ok), withLabels(r.labels, endpoint)...)
+		}
+		if r.breaker != nil {
+			for _, state := range breakerStates {
+				ch <- prometheus.MustNewConstMetric(c.breakerState, prometheus.GaugeValue, boolToFloat(state == *r.breaker), withLabels(r.labels, state.String())...)
+			}
+		}
+		if r.cache != nil {
+			if !r.cache.lastSuccess.IsZero() {
+				ch <- prometheus.MustNewConstMetric(c.lastSuccess, prometheus.GaugeValue, float64(r.cache.lastSuccess.UnixNano())/1e9, r.labels...)
+			}
+			if !r.cache.fetchedAt.IsZero() {
+				ch <- prometheus.MustNewConstMetric(c.snapshotAge, prometheus.GaugeValue, now.Sub(r.cache.fetchedAt).Seconds(), r.labels...)
+			}
+		}
+		c.countScrape(r)
+		if errors.Is(r.err, ErrStale) || errors.Is(r.err, ErrNotPolled) {
+			log.Printf("warn: no fresh snapshot for target=%s err=%v", r.target, r.err)
+			ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0, r.labels...)
+			continue
+		}
+		if errors.Is(r.err, ErrCircuitOpen) {
+			log.Printf("warn: scrape skipped for target=%s reason=circuit_open", r.target)
+			ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0, r.labels...)
+			continue
+		}
+		if r.err != nil && !r.snapshot.Reachable() {
 			log.Printf("warn: scrape failed for target=%s err=%v", r.target, r.err)
-			ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0, r.target)
+			ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0, r.labels...)
 			continue
 		}
+		if r.err != nil {
+			log.Printf("warn: partial scrape for target=%s err=%v", r.target, r.err)
+		}
 
-		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1, r.target)
-		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, r.target, r.snapshot.Name, r.snapshot.ProductName, r.snapshot.HardwareVersion, r.snapshot.SoftwareVersion)
-		ch <- prometheus.MustNewConstMetric(c.hddSizeBytes, prometheus.GaugeValue, r.snapshot.HDDSizeBytes, r.target)
-		ch <- prometheus.MustNewConstMetric(c.hddUsageBytes, prometheus.GaugeValue, r.snapshot.HDDUsageBytes, r.target)
-		ch <- prometheus.MustNewConstMetric(c.dtcpipClients, prometheus.GaugeValue, r.snapshot.DTCPIPClients, r.target)
-		ch <- prometheus.MustNewConstMetric(c.recordings, prometheus.GaugeValue, r.snapshot.Recordings, r.target)
-		ch <- prometheus.MustNewConstMetric(c.recordedTitles, prometheus.GaugeValue, r.snapshot.RecordedTitles, r.target)
-		ch <- prometheus.MustNewConstMetric(c.reservedTitles, prometheus.GaugeValue, r.snapshot.ReservedTitles, r.target)
-		ch <- prometheus.MustNewConstMetric(c.reservedConflict, prometheus.GaugeValue, r.snapshot.ReservedConflictTitles, r.target)
-		ch <- prometheus.MustNewConstMetric(c.reservedNotFound, prometheus.GaugeValue, r.snapshot.ReservedNotFoundTitles, r.target)
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1, r.labels...)
+		c.collectSnapshot(ch, r.labels, r.snapshot, now)
 	}
 
 	c.mu.Lock()
-	c.lastScrapeErrors = errors
+	c.lastScrapeErrors = scrapeErrors
 	c.hasScrapedOnce = true
+	for k, v := range c.scrapes {
+		ch <- prometheus.MustNewConstMetric(c.scrapesTotal, prometheus.CounterValue, v, withLabels(c.counterValues[k[0]], k[1])...)
+	}
+	for k, v := range c.scrapeErrors {
+		ch <- prometheus.MustNewConstMetric(c.scrapeErrorsTot, prometheus.CounterValue, v, withLabels(c.counterValues[k[0]], k[1])...)
+	}
+	for target, n := range c.coalescedCounts() {
+		ch <- prometheus.MustNewConstMetric(c.coalescedTotal, prometheus.CounterValue, n, c.counterValues[target]...)
+	}
 	c.mu.Unlock()
 }
 
+// labelValuesFor returns the target label values for a snapshot of t and
+// remembers those of the counters, which are emitted after all targets.
+func (c *Collector) labelValuesFor(t TargetFetcher, s nasne.Snapshot) []string {
+	values := []string{t.Target}
+	for _, name := range c.staticLabels {
+		values = append(values, t.Labels[name])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counterValues[t.Target] = values
+	if c.boxNameLabel {
+		if s.Has(nasne.EndpointBoxName) && s.Name != "" {
+			c.boxNames[t.Target] = s.Name
+		}
+		values = append(slices.Clip(values), c.boxNames[t.Target])
+	}
+	return values
+}
+
+// reservedLabels are the label names of the exporter's own metrics, which
+// static target labels must not reuse.
+var reservedLabels = []string{
+	"target", "box_name",
+	"endpoint", "group", "port", "code", "result", "reason", "state", "kind",
+	"name", "product_name", "hardware_version", "software_version",
+	"hdd_id", "type", "format",
+	"network_id", "transport_stream_id", "service_id",
+	"ip_address", "mac_address", "client_type",
+	"genre", "channel",
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateStaticLabel reports an error if name cannot be used as a static
+// target label: it must be a valid Prometheus label name and must not be one
+// of the labels the exporter sets itself.
+func ValidateStaticLabel(name string) error {
+	switch {
+	case !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__"):
+		return fmt.Errorf("invalid label name %q", name)
+	case slices.Contains(reservedLabels, name):
+		return fmt.Errorf("label name %q is reserved for the exporter's own labels", name)
+	}
+	return nil
+}
+
+// StaticLabelNames returns the sorted union of the names in the static
+// labels of all targets.
+func StaticLabelNames(labels ...map[string]string) []string {
+	var names []string
+	for _, l := range labels {
+		for name := range l {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// countScrape updates the scrape result and error reason counters for r.
+func (c *Collector) countScrape(r collectResult) {
+	result := "success"
+	switch {
+	case r.err != nil && r.snapshot.Reachable():
+		result = "partial"
+	case r.err != nil:
+		result = "failure"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scrapes[[2]string{r.target, result}]++
+	if r.err != nil {
+		for _, reason := range scrapeErrorReasons(r.err) {
+			c.scrapeErrors[[2]string{r.target, reason}]++
+		}
+	}
+}
+
+// scrapeErrorReasons returns the distinct reasons behind a failed scrape,
+// one per failure class rather than one per failed endpoint.
+func scrapeErrorReasons(err error) []string {
+	switch {
+	case errors.Is(err, ErrStale):
+		return []string{"stale"}
+	case errors.Is(err, ErrNotPolled):
+		return []string{"not_polled"}
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return []string{"circuit_open"}
+	}
+	var fe *nasne.FetchError
+	if !errors.As(err, &fe) {
+		return []string{nasne.Reason(err)}
+	}
+	seen := map[string]struct{}{}
+	var reasons []string
+	for _, e := range fe.Errors {
+		reason := nasne.Reason(e)
+		if _, ok := seen[reason]; ok {
+			continue
+		}
+		seen[reason] = struct{}{}
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// collectSnapshot emits the gauges backed by s, skipping those whose
+// endpoints were not fetched successfully.
+func (c *Collector) collectSnapshot(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, now time.Time) {
+	for _, sub := range c.subs {
+		sub.Collect(ch, target, s, now)
+	}
+}
+
 func (c *Collector) Healthy() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return len(c.targets) > 0 && c.hasScrapedOnce && len(c.lastScrapeErrors) == 0
 }
+
+// upcomingReservations returns the earliest reservation starting after now
+// (nil when nothing is scheduled) and how many start within the next 24 hours.
+func upcomingReservations(reservations []nasne.Reservation, now time.Time) (*nasne.Reservation, float64) {
+	var (
+		next *nasne.Reservation
+		soon float64
+	)
+	horizon := now.Add(24 * time.Hour)
+	for i := range reservations {
+		res := &reservations[i]
+		if !res.Start.After(now) {
+			continue
+		}
+		if res.Start.Before(horizon) {
+			soon++
+		}
+		if next == nil || res.Start.Before(next.Start) {
+			next = res
+		}
+	}
+	return next, soon
+}
+
+// limitDTCPIPClients returns at most limit clients in a stable order so the
+// exported series don't flap when the device reorders its list.
+func limitDTCPIPClients(clients []nasne.DTCPIPClient, limit int) []nasne.DTCPIPClient {
+	sorted := append([]nasne.DTCPIPClient(nil), clients...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].IPAddress != sorted[j].IPAddress {
+			return sorted[i].IPAddress < sorted[j].IPAddress
+		}
+		if sorted[i].MACAddress != sorted[j].MACAddress {
+			return sorted[i].MACAddress < sorted[j].MACAddress
+		}
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Type < sorted[j].Type
+	})
+	out := sorted[:0]
+	for i, cl := range sorted {
+		if i > 0 && cl == sorted[i-1] {
+			continue
+		}
+		if len(out) == limit {
+			break
+		}
+		out = append(out, cl)
+	}
+	return out
+}
+
+func hddType(hdd nasne.HDD) string {
+	if hdd.Internal {
+		return "internal"
+	}
+	return "external"
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -3,10 +3,14 @@ package exporter
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
 )
@@ -62,3 +66,381 @@ func TestCollectorHealthyOnPartialError(t *testing.T) {
 		t.Fatal("collector should be unhealthy when at least one target fails")
 	}
 }
+
+func TestCollectorPerHDDMetrics(t *testing.T) {
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: nasne.Snapshot{
+		HDDSizeBytes:  3000,
+		HDDUsageBytes: 1500,
+		HDDs: []nasne.HDD{
+			{ID: 0, Internal: true, Mounted: true, Format: "xfs", SizeBytes: 1000, UsageBytes: 500},
+			{ID: 1, Internal: false, Mounted: true, Format: "xfs", SizeBytes: 2000, UsageBytes: 1000},
+		},
+	}}}}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	if v, ok := gaugeValue(mfs, "nasne_hdd_size_bytes", nil); !ok || v != 3000 {
+		t.Fatalf("unexpected aggregate size: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_hdd_disk_size_bytes", map[string]string{"hdd_id": "1", "type": "external"}); !ok || v != 2000 {
+		t.Fatalf("unexpected external disk size: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_hdd_disk_usage_bytes", map[string]string{"hdd_id": "0", "type": "internal"}); !ok || v != 500 {
+		t.Fatalf("unexpected internal disk usage: %v (found=%v)", v, ok)
+	}
+}
+
+func gaugeValue(mfs []*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for k, v := range labels {
+				found := false
+				for _, lp := range m.GetLabel() {
+					if lp.GetName() == k && lp.GetValue() == v {
+						found = true
+						break
+					}
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			switch {
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue(), true
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestCollectorNextReservation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: nasne.Snapshot{
+		Reservations: []nasne.Reservation{
+			{Start: now.Add(-time.Hour), Duration: 2 * time.Hour},
+			{Start: now.Add(30 * time.Hour), Duration: time.Hour},
+			{Start: now.Add(2 * time.Hour), Duration: 30 * time.Minute},
+			{Start: now.Add(5 * time.Hour), Duration: time.Hour},
+		},
+	}}}}, time.Second)
+	c.now = func() time.Time { return now }
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	if v, ok := gaugeValue(mfs, "nasne_next_reservation_start_timestamp_seconds", nil); !ok || v != float64(now.Add(2*time.Hour).Unix()) {
+		t.Fatalf("unexpected next reservation start: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_next_reservation_duration_seconds", nil); !ok || v != 1800 {
+		t.Fatalf("unexpected next reservation duration: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_reservations_next_24h", nil); !ok || v != 2 {
+		t.Fatalf("unexpected reservations in next 24h: %v (found=%v)", v, ok)
+	}
+}
+
+func TestCollectorNoUpcomingReservation(t *testing.T) {
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: nasne.Snapshot{}}}}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	if _, ok := gaugeValue(mfs, "nasne_next_reservation_start_timestamp_seconds", nil); ok {
+		t.Fatal("next reservation start should be absent when nothing is scheduled")
+	}
+	if v, ok := gaugeValue(mfs, "nasne_reservations_next_24h", nil); !ok || v != 0 {
+		t.Fatalf("unexpected reservations in next 24h: %v (found=%v)", v, ok)
+	}
+}
+
+func TestCollectorTunerState(t *testing.T) {
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: nasne.Snapshot{
+		Recordings:   0,
+		TunerState:   nasne.TunerStateStreaming,
+		TunerChannel: nasne.TunerChannel{NetworkID: 32736, TransportStreamID: 32736, ServiceID: 1024},
+	}}}}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	for _, state := range nasne.TunerStates {
+		want := 0.0
+		if state == nasne.TunerStateStreaming {
+			want = 1
+		}
+		if v, ok := gaugeValue(mfs, "nasne_tuner_state", map[string]string{"state": state}); !ok || v != want {
+			t.Fatalf("unexpected tuner state %s: %v (found=%v)", state, v, ok)
+		}
+	}
+	if _, ok := gaugeValue(mfs, "nasne_tuner_channel_info", map[string]string{"service_id": "1024"}); !ok {
+		t.Fatal("tuner channel info should be exported while tuned")
+	}
+}
+
+func TestCollectorDTCPIPClientInfoLimit(t *testing.T) {
+	snapshot := nasne.Snapshot{
+		DTCPIPClients: 3,
+		DTCPIPClientList: []nasne.DTCPIPClient{
+			{Name: "PS4", IPAddress: "192.168.11.20"},
+			{Name: "PS Vita", IPAddress: "192.168.11.10"},
+			{Name: "PS3", IPAddress: "192.168.11.30"},
+		},
+	}
+	targets := []TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: snapshot}}}
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(NewCollector(targets, time.Second))
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if _, ok := gaugeValue(mfs, "nasne_dtcpip_client_info", nil); ok {
+		t.Fatal("client info should be disabled by default")
+	}
+
+	r = prometheus.NewRegistry()
+	r.MustRegister(NewCollector(targets, time.Second, WithDTCPIPClientInfo(2)))
+	mfs, err = r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "nasne_dtcpip_client_info" && len(mf.GetMetric()) != 2 {
+			t.Fatalf("unexpected client info series: %d", len(mf.GetMetric()))
+		}
+	}
+	if _, ok := gaugeValue(mfs, "nasne_dtcpip_client_info", map[string]string{"name": "PS3"}); ok {
+		t.Fatal("clients beyond the limit should not be exported")
+	}
+}
+
+func TestCollectorPartialSnapshot(t *testing.T) {
+	snapshot := nasne.Snapshot{
+		HDDSizeBytes:   1000,
+		ReservedTitles: 5,
+		Endpoints: map[string]bool{
+			nasne.EndpointHDDList:      true,
+			nasne.EndpointHDDInfo:      true,
+			nasne.EndpointReservedList: false,
+		},
+	}
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: partialFetcher{snapshot: snapshot, err: errors.New("reserved list failed")}}}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	if v, ok := gaugeValue(mfs, "nasne_up", nil); !ok || v != 1 {
+		t.Fatalf("partial scrape should keep up=1: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_endpoint_up", map[string]string{"endpoint": nasne.EndpointReservedList}); !ok || v != 0 {
+		t.Fatalf("unexpected endpoint_up for failed endpoint: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_hdd_size_bytes", nil); !ok || v != 1000 {
+		t.Fatalf("fetched HDD data should be exported: %v (found=%v)", v, ok)
+	}
+	if _, ok := gaugeValue(mfs, "nasne_reserved_titles", nil); ok {
+		t.Fatal("reserved titles should be absent when the endpoint failed")
+	}
+	if c.Healthy() {
+		t.Fatal("collector should be unhealthy after a partial scrape")
+	}
+}
+
+// partialFetcher returns its snapshot together with its error, like
+// nasne.Client does when only some endpoints fail.
+type partialFetcher struct {
+	snapshot nasne.Snapshot
+	err      error
+}
+
+func (f partialFetcher) FetchSnapshot(_ context.Context) (nasne.Snapshot, error) {
+	return f.snapshot, f.err
+}
+
+func TestCollectorScrapeCounters(t *testing.T) {
+	fetchErr := &nasne.FetchError{Errors: []*nasne.EndpointError{
+		{Endpoint: nasne.EndpointRecordedTitleList, Err: fmt.Errorf("request: %w", nasne.ErrTimeout)},
+		{Endpoint: nasne.EndpointReservedList, Err: fmt.Errorf("request: %w", nasne.ErrTimeout)},
+	}}
+	c := NewCollector([]TargetFetcher{
+		{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: nasne.Snapshot{Name: "nasne-a"}}},
+		{Target: "192.168.11.2:64210", Fetcher: partialFetcher{
+			snapshot: nasne.Snapshot{Endpoints: map[string]bool{nasne.EndpointBoxName: true, nasne.EndpointRecordedTitleList: false, nasne.EndpointReservedList: false}},
+			err:      fetchErr,
+		}},
+	}, time.Second)
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	var mfs []*dto.MetricFamily
+	for i := 0; i < 2; i++ {
+		var err error
+		if mfs, err = r.Gather(); err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+	}
+
+	if v, ok := gaugeValue(mfs, "nasne_scrapes_total", map[string]string{"target": "192.168.11.1:64210", "result": "success"}); !ok || v != 2 {
+		t.Fatalf("unexpected success count: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_scrapes_total", map[string]string{"target": "192.168.11.2:64210", "result": "partial"}); !ok || v != 2 {
+		t.Fatalf("unexpected partial count: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_scrape_errors_total", map[string]string{"target": "192.168.11.2:64210", "reason": "timeout"}); !ok || v != 2 {
+		t.Fatalf("unexpected timeout count: %v (found=%v)", v, ok)
+	}
+}
+
+func TestCollectorTargetLabels(t *testing.T) {
+	var down bool
+	living := fetcherFunc(func(context.Context) (nasne.Snapshot, error) {
+		if down {
+			return nasne.Snapshot{}, nasne.ErrUnreachable
+		}
+		return nasne.Snapshot{Name: "nasne-living"}, nil
+	})
+	c := NewCollector([]TargetFetcher{
+		{Target: "living", Fetcher: living, Labels: map[string]string{"room": "living"}},
+		{Target: "192.168.11.2:64210", Fetcher: fakeFetcher{err: nasne.ErrUnreachable}, Labels: map[string]string{"site": "parents"}},
+	}, time.Second, WithBoxNameLabel())
+	r := prometheus.NewRegistry()
+	if err := r.Register(c); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	for _, down = range []bool{false, true} {
+		mfs, err := r.Gather()
+		if err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+		want := map[string]string{"target": "living", "room": "living", "site": "", "box_name": "nasne-living"}
+		if _, ok := gaugeValue(mfs, "nasne_up", want); !ok {
+			t.Fatalf("down=%t: no nasne_up with labels %v", down, want)
+		}
+		counter := map[string]string{"target": "living", "room": "living", "site": ""}
+		if _, ok := gaugeValue(mfs, "nasne_scrapes_total", counter); !ok {
+			t.Fatalf("down=%t: no nasne_scrapes_total with labels %v", down, counter)
+		}
+		want = map[string]string{"target": "192.168.11.2:64210", "room": "", "site": "parents", "box_name": ""}
+		if v, ok := gaugeValue(mfs, "nasne_up", want); !ok || v != 0 {
+			t.Fatalf("down=%t: unexpected nasne_up %v (found=%v) for %v", down, v, ok, want)
+		}
+	}
+
+	clash := NewCollector([]TargetFetcher{{Target: "a", Fetcher: living, Labels: map[string]string{"state": "x"}}}, time.Second)
+	if err := prometheus.NewRegistry().Register(clash); err == nil {
+		t.Fatal("expected an error for a static label clashing with a metric label")
+	}
+}
+
+func TestCollectorCountersWithoutBoxName(t *testing.T) {
+	snapshots := []nasne.Snapshot{
+		{Endpoints: map[string]bool{nasne.EndpointBoxName: false, nasne.EndpointHDDList: true}},
+		{Name: "nasne-a", Endpoints: map[string]bool{nasne.EndpointBoxName: true, nasne.EndpointHDDList: true}},
+		{Name: "nasne-b", Endpoints: map[string]bool{nasne.EndpointBoxName: true, nasne.EndpointHDDList: true}},
+	}
+	var scrape int
+	fetcher := fetcherFunc(func(context.Context) (nasne.Snapshot, error) {
+		s := snapshots[scrape]
+		if !s.Endpoints[nasne.EndpointBoxName] {
+			return s, &nasne.FetchError{Errors: []*nasne.EndpointError{{Endpoint: nasne.EndpointBoxName, Err: nasne.ErrTimeout}}}
+		}
+		return s, nil
+	})
+	c := NewCollector([]TargetFetcher{{Target: "living", Fetcher: fetcher}}, time.Second, WithBoxNameLabel())
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	var mfs []*dto.MetricFamily
+	for scrape = range snapshots {
+		var err error
+		if mfs, err = r.Gather(); err != nil {
+			t.Fatalf("scrape %d: gather failed: %v", scrape, err)
+		}
+	}
+	if _, ok := gaugeValue(mfs, "nasne_up", map[string]string{"box_name": "nasne-b"}); !ok {
+		t.Fatal("nasne_up should carry the latest box name")
+	}
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "nasne_scrapes_total", "nasne_scrape_errors_total":
+		default:
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "box_name" {
+					t.Fatalf("%s carries box_name: %v", mf.GetName(), m.GetLabel())
+				}
+			}
+		}
+	}
+	if v, ok := gaugeValue(mfs, "nasne_scrapes_total", map[string]string{"result": "success"}); !ok || v != 2 {
+		t.Fatalf("successful scrapes = %v (found=%v), want 2 in one series", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_scrapes_total", map[string]string{"result": "partial"}); !ok || v != 1 {
+		t.Fatalf("partial scrapes = %v (found=%v), want 1", v, ok)
+	}
+}
+
+func TestValidateStaticLabel(t *testing.T) {
+	for _, name := range []string{"room", "site", "_zone", "Room2"} {
+		if err := ValidateStaticLabel(name); err != nil {
+			t.Errorf("ValidateStaticLabel(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "room-name", "2room", "__name__", "target", "box_name", "state", "endpoint", "genre"} {
+		if err := ValidateStaticLabel(name); err == nil {
+			t.Errorf("ValidateStaticLabel(%q) accepted", name)
+		}
+	}
+
+	// Every label the exporter sets itself must be reserved.
+	c := NewCollector(nil, time.Second, WithBoxNameLabel(), WithDTCPIPClientInfo(1))
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		NewRequestMetrics().Describe(ch)
+		close(ch)
+	}()
+	variableLabels := regexp.MustCompile(`variableLabels: \{([^}]*)\}`)
+	for desc := range ch {
+		m := variableLabels.FindStringSubmatch(desc.String())
+		if m == nil {
+			t.Fatalf("no variable labels in %s", desc)
+		}
+		for _, name := range strings.Split(m[1], ",") {
+			if name != "" && ValidateStaticLabel(name) == nil {
+				t.Errorf("label %q of %s is not reserved", name, desc)
+			}
+		}
+	}
+}
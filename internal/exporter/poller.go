@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+var (
+	// ErrNotPolled is returned by a Poller before its first poll completed.
+	ErrNotPolled = errors.New("not polled yet")
+	// ErrStale is returned by a Poller whose cached snapshot is older than
+	// its max staleness.
+	ErrStale = errors.New("snapshot stale")
+)
+
+// cacheStater is implemented by fetchers that serve cached snapshots.
+type cacheStater interface {
+	// CacheState returns when the cached snapshot was fetched and when a
+	// fetch last succeeded completely; zero times mean never.
+	CacheState() (fetchedAt, lastSuccess time.Time)
+}
+
+// Poller fetches a snapshot from the wrapped Fetcher in the background and
+// serves the latest one from FetchSnapshot, so scrapes never wait for the
+// device. A poll in which the device could not be reached keeps the previous
+// snapshot; once that is older than maxStaleness, FetchSnapshot fails with
+// ErrStale. A maxStaleness of 0 serves the last snapshot indefinitely.
+type Poller struct {
+	fetcher      Fetcher
+	interval     time.Duration
+	jitter       time.Duration
+	timeout      time.Duration
+	maxStaleness time.Duration
+	now          func() time.Time
+
+	mu          sync.RWMutex
+	snapshot    nasne.Snapshot
+	err         error
+	fetchedAt   time.Time
+	lastSuccess time.Time
+	lastErr     error
+}
+
+// NewPoller returns a Poller that fetches every interval plus a random delay
+// of up to jitter, each fetch bounded by timeout. Call Run to start polling.
+func NewPoller(fetcher Fetcher, interval, jitter, timeout, maxStaleness time.Duration) *Poller {
+	return &Poller{
+		fetcher:      fetcher,
+		interval:     interval,
+		jitter:       jitter,
+		timeout:      timeout,
+		maxStaleness: maxStaleness,
+		now:          time.Now,
+	}
+}
+
+// Run polls until ctx is done. The first poll starts after a random delay of
+// up to jitter so that several targets do not hit the network at once.
+func (p *Poller) Run(ctx context.Context) {
+	timer := time.NewTimer(p.randomJitter())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		p.poll(ctx)
+		timer.Reset(p.interval + p.randomJitter())
+	}
+}
+
+func (p *Poller) randomJitter() time.Duration {
+	if p.jitter <= 0 {
+		return 0
+	}
+	return rand.N(p.jitter)
+}
+
+func (p *Poller) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	snapshot, err := p.fetcher.FetchSnapshot(ctx)
+	cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+	if err != nil && !snapshot.Reachable() {
+		return
+	}
+	now := p.now()
+	p.snapshot, p.err, p.fetchedAt = snapshot, err, now
+	if err == nil {
+		p.lastSuccess = now
+	}
+}
+
+// FetchSnapshot returns the cached snapshot and the error of the poll that
+// produced it (a *nasne.FetchError for partial snapshots).
+func (p *Poller) FetchSnapshot(_ context.Context) (nasne.Snapshot, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	switch {
+	case p.fetchedAt.IsZero() && p.lastErr != nil:
+		return nasne.Snapshot{}, p.lastErr
+	case p.fetchedAt.IsZero():
+		return nasne.Snapshot{}, ErrNotPolled
+	case p.maxStaleness > 0 && p.now().Sub(p.fetchedAt) > p.maxStaleness:
+		err := fmt.Errorf("%w: last fetched %s ago", ErrStale, p.now().Sub(p.fetchedAt).Round(time.Second))
+		if p.lastErr != nil {
+			err = fmt.Errorf("%w: %w", err, p.lastErr)
+		}
+		return nasne.Snapshot{}, err
+	}
+	return p.snapshot, p.err
+}
+
+// CacheState implements cacheStater.
+func (p *Poller) CacheState() (fetchedAt, lastSuccess time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.fetchedAt, p.lastSuccess
+}
+
+// Unwrap returns the polled Fetcher.
+func (p *Poller) Unwrap() Fetcher {
+	return p.fetcher
+}
+
+// findFetcher returns the first fetcher implementing T in the chain of
+// wrappers starting at f, following Unwrap.
+func findFetcher[T any](f Fetcher) (T, bool) {
+	for f != nil {
+		if t, ok := f.(T); ok {
+			return t, true
+		}
+		u, ok := f.(interface{ Unwrap() Fetcher })
+		if !ok {
+			break
+		}
+		f = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+func TestPollerServesCacheUntilStale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &countingFetcher{}
+	p := NewPoller(f, time.Minute, 0, time.Second, 5*time.Minute)
+	p.now = func() time.Time { return now }
+
+	if _, err := p.FetchSnapshot(context.Background()); !errors.Is(err, ErrNotPolled) {
+		t.Fatalf("expected ErrNotPolled before the first poll, got %v", err)
+	}
+
+	p.poll(context.Background())
+	polledAt := now
+	if _, err := p.FetchSnapshot(context.Background()); err != nil {
+		t.Fatalf("unexpected error after successful poll: %v", err)
+	}
+
+	unreachable := errors.New("unreachable")
+	f.err = unreachable
+	now = now.Add(time.Minute)
+	p.poll(context.Background())
+	if _, err := p.FetchSnapshot(context.Background()); err != nil {
+		t.Fatalf("failed poll should keep serving the cached snapshot, got %v", err)
+	}
+	if fetchedAt, lastSuccess := p.CacheState(); !fetchedAt.Equal(polledAt) || !lastSuccess.Equal(polledAt) {
+		t.Fatalf("unexpected cache state: fetched %s, last success %s", fetchedAt, lastSuccess)
+	}
+
+	now = polledAt.Add(6 * time.Minute)
+	_, err := p.FetchSnapshot(context.Background())
+	if !errors.Is(err, ErrStale) || !errors.Is(err, unreachable) {
+		t.Fatalf("expected stale error wrapping the last poll error, got %v", err)
+	}
+}
+
+func TestPollerRun(t *testing.T) {
+	var calls atomic.Int32
+	fetcher := fetcherFunc(func(context.Context) (nasne.Snapshot, error) {
+		calls.Add(1)
+		return nasne.Snapshot{Name: "nasne-a"}, nil
+	})
+	p := NewPoller(fetcher, time.Millisecond, time.Millisecond, time.Second, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("poller ran %d times, want at least 3", calls.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	s, err := p.FetchSnapshot(context.Background())
+	if err != nil || s.Name != "nasne-a" {
+		t.Fatalf("unexpected cached snapshot %+v, err %v", s, err)
+	}
+}
+
+func TestCollectorPolledTarget(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &countingFetcher{}
+	b := NewBreaker(f, 3, time.Minute)
+	p := NewPoller(b, time.Minute, 0, time.Second, 5*time.Minute)
+	p.now = func() time.Time { return now }
+	p.poll(context.Background())
+
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: p}}, time.Second)
+	c.now = func() time.Time { return now.Add(30 * time.Second) }
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if f.calls != 1 {
+		t.Fatalf("scrape should be served from the cache, device fetched %d times", f.calls)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_up", nil); !ok || v != 1 {
+		t.Fatalf("unexpected up: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_snapshot_age_seconds", nil); !ok || v != 30 {
+		t.Fatalf("unexpected snapshot age: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_last_success_timestamp_seconds", nil); !ok || v != float64(now.Unix()) {
+		t.Fatalf("unexpected last success: %v (found=%v)", v, ok)
+	}
+	if _, ok := gaugeValue(mfs, "nasne_circuit_breaker_state", map[string]string{"state": "closed"}); !ok {
+		t.Fatal("breaker state of the polled fetcher is missing")
+	}
+
+	now = now.Add(10 * time.Minute)
+	mfs, err = r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_up", nil); !ok || v != 0 {
+		t.Fatalf("stale target should be down: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_scrape_errors_total", map[string]string{"reason": "stale"}); !ok || v != 1 {
+		t.Fatalf("unexpected stale error count: %v (found=%v)", v, ok)
+	}
+}
+
+type fetcherFunc func(context.Context) (nasne.Snapshot, error)
+
+func (f fetcherFunc) FetchSnapshot(ctx context.Context) (nasne.Snapshot, error) {
+	return f(ctx)
+}
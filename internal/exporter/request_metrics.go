@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// RequestMetrics records the HTTP requests nasne clients make to the device.
+// Register it next to the Collector and pass Observer to each client.
+type RequestMetrics struct {
+	staticLabels []string
+
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	retries  *prometheus.CounterVec
+}
+
+// NewRequestMetrics returns RequestMetrics whose series carry the target
+// label followed by staticLabels, the static label names of all targets (see
+// StaticLabelNames).
+func NewRequestMetrics(staticLabels ...string) *RequestMetrics {
+	targetLabels := append([]string{"target"}, staticLabels...)
+	return &RequestMetrics{
+		staticLabels: staticLabels,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nasne_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests to nasne API endpoints.",
+			Buckets: []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, slices.Concat(targetLabels, []string{"endpoint", "port", "code"})),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nasne_http_request_errors_total",
+			Help: "Failed HTTP requests to nasne API endpoints by reason.",
+		}, slices.Concat(targetLabels, []string{"endpoint", "reason"})),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nasne_http_request_retries_total",
+			Help: "HTTP requests to nasne API endpoints that were retries of a failed attempt.",
+		}, slices.Concat(targetLabels, []string{"endpoint"})),
+	}
+}
+
+func (m *RequestMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.errors.Describe(ch)
+	m.retries.Describe(ch)
+}
+
+func (m *RequestMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.errors.Collect(ch)
+	m.retries.Collect(ch)
+}
+
+// Observer returns a nasne.RequestObserver recording requests for target
+// with its static labels.
+func (m *RequestMetrics) Observer(target string, labels map[string]string) nasne.RequestObserver {
+	values := []string{target}
+	for _, name := range m.staticLabels {
+		values = append(values, labels[name])
+	}
+	return func(e nasne.RequestEvent) {
+		code := "none"
+		if e.Code != 0 {
+			code = strconv.Itoa(e.Code)
+		}
+		m.duration.WithLabelValues(withLabels(values, e.Endpoint, strconv.Itoa(e.Port), code)...).Observe(e.Duration.Seconds())
+		if e.Err != nil {
+			m.errors.WithLabelValues(withLabels(values, e.Endpoint, e.Reason)...).Inc()
+		}
+		if e.Attempt > 1 {
+			m.retries.WithLabelValues(withLabels(values, e.Endpoint)...).Inc()
+		}
+	}
+}
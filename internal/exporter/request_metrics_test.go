@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+func TestRequestMetricsObserver(t *testing.T) {
+	m := NewRequestMetrics("room", "site")
+	r := prometheus.NewRegistry()
+	r.MustRegister(m)
+
+	observe := m.Observer("living", map[string]string{"room": "living"})
+	observe(nasne.RequestEvent{Endpoint: nasne.EndpointBoxName, Port: 64210, Code: 200, Duration: 100 * time.Millisecond})
+	observe(nasne.RequestEvent{Endpoint: nasne.EndpointReservedList, Port: 64220, Attempt: 1, Duration: time.Second, Err: errors.New("boom"), Reason: "timeout"})
+	observe(nasne.RequestEvent{Endpoint: nasne.EndpointReservedList, Port: 64220, Code: 200, Attempt: 2, Duration: 100 * time.Millisecond})
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+
+	var histograms int
+	for _, mf := range mfs {
+		if mf.GetName() == "nasne_http_request_duration_seconds" {
+			histograms = len(mf.GetMetric())
+		}
+	}
+	if histograms != 3 {
+		t.Fatalf("unexpected histogram series: %d", histograms)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_http_request_errors_total", map[string]string{"target": "living", "room": "living", "site": "", "endpoint": nasne.EndpointReservedList, "reason": "timeout"}); !ok || v != 1 {
+		t.Fatalf("unexpected error counter: %v (found=%v)", v, ok)
+	}
+	if v, ok := gaugeValue(mfs, "nasne_http_request_retries_total", map[string]string{"endpoint": nasne.EndpointReservedList}); !ok || v != 1 {
+		t.Fatalf("unexpected retry counter: %v (found=%v)", v, ok)
+	}
+}
@@ -0,0 +1,307 @@
+package exporter
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+// SubCollector exports one group of metrics from a snapshot. Each one is
+// named after the nasne section it needs (see nasne.Sections), so disabling
+// a sub-collector also lets the client skip its endpoints.
+type SubCollector interface {
+	Name() string
+	Describe(ch chan<- *prometheus.Desc)
+	// Collect emits the metrics for one target; target holds the values of
+	// the target labels every series starts with. Implementations check s.Has
+	// for the endpoints they rely on.
+	Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, now time.Time)
+}
+
+// subCollectorFactories builds the sub-collectors by name, in the order of
+// nasne.Sections.
+var subCollectorFactories = map[string]func(c *Collector) SubCollector{
+	nasne.SectionDevice:       func(c *Collector) SubCollector { return newDeviceCollector(c.targetLabels) },
+	nasne.SectionStorage:      func(c *Collector) SubCollector { return newStorageCollector(c.targetLabels) },
+	nasne.SectionTuner:        func(c *Collector) SubCollector { return newTunerCollector(c.targetLabels) },
+	nasne.SectionDTCPIP:       func(c *Collector) SubCollector { return newDTCPIPCollector(c.targetLabels, c.dtcpipClientInfoLimit) },
+	nasne.SectionRecorded:     func(c *Collector) SubCollector { return newRecordedCollector(c.targetLabels) },
+	nasne.SectionReservations: func(c *Collector) SubCollector { return newReservationsCollector(c.targetLabels) },
+}
+
+// SubCollectorNames lists the available sub-collectors.
+func SubCollectorNames() []string {
+	return slices.Clone(nasne.Sections)
+}
+
+// ValidateSubCollectors reports an error for names that are not in
+// SubCollectorNames.
+func ValidateSubCollectors(names []string) error {
+	for _, name := range names {
+		if _, ok := subCollectorFactories[name]; !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
+
+// WithSubCollectors enables only the named sub-collectors; by default all
+// are enabled. Unknown names are ignored, see ValidateSubCollectors.
+func WithSubCollectors(names ...string) Option {
+	return func(c *Collector) {
+		c.enabled = names
+	}
+}
+
+type deviceCollector struct {
+	info *prometheus.Desc
+}
+
+func newDeviceCollector(targetLabels []string) *deviceCollector {
+	return &deviceCollector{
+		info: newDesc(targetLabels, "nasne_info", "nasne device information.", "name", "product_name", "hardware_version", "software_version"),
+	}
+}
+
+func (d *deviceCollector) Name() string { return nasne.SectionDevice }
+
+func (d *deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.info
+}
+
+func (d *deviceCollector) Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, _ time.Time) {
+	if s.Has(nasne.EndpointBoxName) || s.Has(nasne.EndpointHardwareVersion) || s.Has(nasne.EndpointSoftwareVersion) {
+		ch <- prometheus.MustNewConstMetric(d.info, prometheus.GaugeValue, 1, withLabels(target, s.Name, s.ProductName, s.HardwareVersion, s.SoftwareVersion)...)
+	}
+}
+
+type storageCollector struct {
+	sizeBytes  *prometheus.Desc
+	usageBytes *prometheus.Desc
+	info       *prometheus.Desc
+	mounted    *prometheus.Desc
+	diskSize   *prometheus.Desc
+	diskUsage  *prometheus.Desc
+}
+
+func newStorageCollector(targetLabels []string) *storageCollector {
+	return &storageCollector{
+		sizeBytes:  newDesc(targetLabels, "nasne_hdd_size_bytes", "Total HDD size in bytes."),
+		usageBytes: newDesc(targetLabels, "nasne_hdd_usage_bytes", "Used HDD size in bytes."),
+		info:       newDesc(targetLabels, "nasne_hdd_info", "nasne HDD information.", "hdd_id", "type", "format"),
+		mounted:    newDesc(targetLabels, "nasne_hdd_mounted", "Whether the HDD is mounted.", "hdd_id", "type"),
+		diskSize:   newDesc(targetLabels, "nasne_hdd_disk_size_bytes", "Total size of a single HDD in bytes.", "hdd_id", "type"),
+		diskUsage:  newDesc(targetLabels, "nasne_hdd_disk_usage_bytes", "Used size of a single HDD in bytes.", "hdd_id", "type"),
+	}
+}
+
+func (d *storageCollector) Name() string { return nasne.SectionStorage }
+
+func (d *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.sizeBytes
+	ch <- d.usageBytes
+	ch <- d.info
+	ch <- d.mounted
+	ch <- d.diskSize
+	ch <- d.diskUsage
+}
+
+func (d *storageCollector) Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, _ time.Time) {
+	if !s.Has(nasne.EndpointHDDList, nasne.EndpointHDDInfo) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.sizeBytes, prometheus.GaugeValue, s.HDDSizeBytes, target...)
+	ch <- prometheus.MustNewConstMetric(d.usageBytes, prometheus.GaugeValue, s.HDDUsageBytes, target...)
+	for _, hdd := range s.HDDs {
+		id := strconv.Itoa(hdd.ID)
+		typ := hddType(hdd)
+		ch <- prometheus.MustNewConstMetric(d.info, prometheus.GaugeValue, 1, withLabels(target, id, typ, hdd.Format)...)
+		ch <- prometheus.MustNewConstMetric(d.mounted, prometheus.GaugeValue, boolToFloat(hdd.Mounted), withLabels(target, id, typ)...)
+		ch <- prometheus.MustNewConstMetric(d.diskSize, prometheus.GaugeValue, hdd.SizeBytes, withLabels(target, id, typ)...)
+		ch <- prometheus.MustNewConstMetric(d.diskUsage, prometheus.GaugeValue, hdd.UsageBytes, withLabels(target, id, typ)...)
+	}
+}
+
+type tunerCollector struct {
+	recordings *prometheus.Desc
+	state      *prometheus.Desc
+	channel    *prometheus.Desc
+}
+
+func newTunerCollector(targetLabels []string) *tunerCollector {
+	return &tunerCollector{
+		recordings: newDesc(targetLabels, "nasne_recordings", "Number of current recordings."),
+		state:      newDesc(targetLabels, "nasne_tuner_state", "Current tuner state; the series for the active state is 1, all others 0.", "state"),
+		channel:    newDesc(targetLabels, "nasne_tuner_channel_info", "Broadcast service the tuner is currently tuned to.", "network_id", "transport_stream_id", "service_id"),
+	}
+}
+
+func (d *tunerCollector) Name() string { return nasne.SectionTuner }
+
+func (d *tunerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.recordings
+	ch <- d.state
+	ch <- d.channel
+}
+
+func (d *tunerCollector) Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, _ time.Time) {
+	if !s.Has(nasne.EndpointBoxStatusList) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.recordings, prometheus.GaugeValue, s.Recordings, target...)
+	for _, state := range nasne.TunerStates {
+		ch <- prometheus.MustNewConstMetric(d.state, prometheus.GaugeValue, boolToFloat(state == s.TunerState), withLabels(target, state)...)
+	}
+	if tc := s.TunerChannel; tc != (nasne.TunerChannel{}) {
+		ch <- prometheus.MustNewConstMetric(d.channel, prometheus.GaugeValue, 1, withLabels(target, strconv.Itoa(tc.NetworkID), strconv.Itoa(tc.TransportStreamID), strconv.Itoa(tc.ServiceID))...)
+	}
+}
+
+type dtcpipCollector struct {
+	infoLimit  int
+	clients    *prometheus.Desc
+	clientInfo *prometheus.Desc
+}
+
+func newDTCPIPCollector(targetLabels []string, infoLimit int) *dtcpipCollector {
+	return &dtcpipCollector{
+		infoLimit:  infoLimit,
+		clients:    newDesc(targetLabels, "nasne_dtcpip_clients", "Connected DTCP-IP clients."),
+		clientInfo: newDesc(targetLabels, "nasne_dtcpip_client_info", "Connected DTCP-IP client details.", "name", "ip_address", "mac_address", "client_type"),
+	}
+}
+
+func (d *dtcpipCollector) Name() string { return nasne.SectionDTCPIP }
+
+func (d *dtcpipCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.clients
+	if d.infoLimit > 0 {
+		ch <- d.clientInfo
+	}
+}
+
+func (d *dtcpipCollector) Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, _ time.Time) {
+	if !s.Has(nasne.EndpointDTCPIPClientList) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.clients, prometheus.GaugeValue, s.DTCPIPClients, target...)
+	if d.infoLimit > 0 {
+		for _, cl := range limitDTCPIPClients(s.DTCPIPClientList, d.infoLimit) {
+			ch <- prometheus.MustNewConstMetric(d.clientInfo, prometheus.GaugeValue, 1, withLabels(target, cl.Name, cl.IPAddress, cl.MACAddress, cl.Type)...)
+		}
+	}
+}
+
+type recordedCollector struct {
+	titles          *prometheus.Desc
+	byGenre         *prometheus.Desc
+	byChannel       *prometheus.Desc
+	duration        *prometheus.Desc
+	byState         *prometheus.Desc
+	oldestUnwatched *prometheus.Desc
+}
+
+func newRecordedCollector(targetLabels []string) *recordedCollector {
+	return &recordedCollector{
+		titles:          newDesc(targetLabels, "nasne_recorded_titles", "Number of recorded titles."),
+		byGenre:         newDesc(targetLabels, "nasne_recorded_titles_by_genre", "Number of recorded titles per ARIB top-level genre.", "genre"),
+		byChannel:       newDesc(targetLabels, "nasne_recorded_titles_by_channel", "Number of recorded titles per channel.", "channel"),
+		duration:        newDesc(targetLabels, "nasne_recorded_duration_seconds_total", "Summed duration of all recorded titles."),
+		byState:         newDesc(targetLabels, "nasne_recorded_titles_by_state", "Number of recorded titles per state (unwatched, watched, protected).", "state"),
+		oldestUnwatched: newDesc(targetLabels, "nasne_recorded_oldest_unwatched_age_seconds", "Age of the oldest unwatched recording."),
+	}
+}
+
+func (d *recordedCollector) Name() string { return nasne.SectionRecorded }
+
+func (d *recordedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.titles
+	ch <- d.byGenre
+	ch <- d.byChannel
+	ch <- d.duration
+	ch <- d.byState
+	ch <- d.oldestUnwatched
+}
+
+func (d *recordedCollector) Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, now time.Time) {
+	if !s.Has(nasne.EndpointRecordedTitleList) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.titles, prometheus.GaugeValue, s.RecordedTitles, target...)
+	for genre, n := range s.RecordedTitlesByGenre {
+		ch <- prometheus.MustNewConstMetric(d.byGenre, prometheus.GaugeValue, n, withLabels(target, genre)...)
+	}
+	for channel, n := range s.RecordedTitlesByChannel {
+		ch <- prometheus.MustNewConstMetric(d.byChannel, prometheus.GaugeValue, n, withLabels(target, channel)...)
+	}
+	ch <- prometheus.MustNewConstMetric(d.duration, prometheus.GaugeValue, s.RecordedDurationSeconds, target...)
+	for state, n := range s.RecordedTitlesByState {
+		ch <- prometheus.MustNewConstMetric(d.byState, prometheus.GaugeValue, n, withLabels(target, state)...)
+	}
+	if oldest := s.OldestUnwatchedStart; !oldest.IsZero() {
+		ch <- prometheus.MustNewConstMetric(d.oldestUnwatched, prometheus.GaugeValue, now.Sub(oldest).Seconds(), target...)
+	}
+}
+
+type reservationsCollector struct {
+	titles    *prometheus.Desc
+	conflict  *prometheus.Desc
+	notFound  *prometheus.Desc
+	nextStart *prometheus.Desc
+	nextDur   *prometheus.Desc
+	soon      *prometheus.Desc
+}
+
+func newReservationsCollector(targetLabels []string) *reservationsCollector {
+	return &reservationsCollector{
+		titles:    newDesc(targetLabels, "nasne_reserved_titles", "Number of reserved titles."),
+		conflict:  newDesc(targetLabels, "nasne_reserved_conflict_titles", "Number of conflicting reserved titles."),
+		notFound:  newDesc(targetLabels, "nasne_reserved_notfound_titles", "Number of not-found reserved titles."),
+		nextStart: newDesc(targetLabels, "nasne_next_reservation_start_timestamp_seconds", "Start time of the next upcoming reservation as a Unix timestamp."),
+		nextDur:   newDesc(targetLabels, "nasne_next_reservation_duration_seconds", "Duration of the next upcoming reservation."),
+		soon:      newDesc(targetLabels, "nasne_reservations_next_24h", "Number of reservations starting within the next 24 hours."),
+	}
+}
+
+func (d *reservationsCollector) Name() string { return nasne.SectionReservations }
+
+func (d *reservationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.titles
+	ch <- d.conflict
+	ch <- d.notFound
+	ch <- d.nextStart
+	ch <- d.nextDur
+	ch <- d.soon
+}
+
+func (d *reservationsCollector) Collect(ch chan<- prometheus.Metric, target []string, s nasne.Snapshot, now time.Time) {
+	if !s.Has(nasne.EndpointReservedList) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.titles, prometheus.GaugeValue, s.ReservedTitles, target...)
+	ch <- prometheus.MustNewConstMetric(d.conflict, prometheus.GaugeValue, s.ReservedConflictTitles, target...)
+	ch <- prometheus.MustNewConstMetric(d.notFound, prometheus.GaugeValue, s.ReservedNotFoundTitles, target...)
+
+	next, soon := upcomingReservations(s.Reservations, now)
+	ch <- prometheus.MustNewConstMetric(d.soon, prometheus.GaugeValue, soon, target...)
+	if next != nil {
+		ch <- prometheus.MustNewConstMetric(d.nextStart, prometheus.GaugeValue, float64(next.Start.Unix()), target...)
+		ch <- prometheus.MustNewConstMetric(d.nextDur, prometheus.GaugeValue, next.Duration.Seconds(), target...)
+	}
+}
+
+// newDesc returns a Desc whose variable labels are the target labels followed
+// by labels.
+func newDesc(targetLabels []string, name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, slices.Concat(targetLabels, labels), nil)
+}
+
+// withLabels returns the target label values followed by values.
+func withLabels(target []string, values ...string) []string {
+	return slices.Concat(target, values)
+}
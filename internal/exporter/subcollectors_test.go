@@ -0,0 +1,46 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ryomaholiday/nasne_exporter/internal/nasne"
+)
+
+func TestCollectorSubCollectorSelection(t *testing.T) {
+	snapshot := nasne.Snapshot{Name: "nasne-a", RecordedTitles: 12, ReservedTitles: 3, HDDSizeBytes: 1000}
+	c := NewCollector([]TargetFetcher{{Target: "192.168.11.1:64210", Fetcher: fakeFetcher{snapshot: snapshot}}}, time.Second,
+		WithSubCollectors(nasne.SectionDevice, nasne.SectionStorage))
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	if _, ok := gaugeValue(mfs, "nasne_info", map[string]string{"name": "nasne-a"}); !ok {
+		t.Fatal("nasne_info missing with the device collector enabled")
+	}
+	if v, ok := gaugeValue(mfs, "nasne_hdd_size_bytes", nil); !ok || v != 1000 {
+		t.Fatalf("unexpected nasne_hdd_size_bytes: %v (found=%v)", v, ok)
+	}
+	for _, name := range []string{"nasne_recorded_titles", "nasne_reserved_titles", "nasne_tuner_state", "nasne_dtcpip_clients"} {
+		if _, ok := gaugeValue(mfs, name, nil); ok {
+			t.Errorf("%s exported although its collector is disabled", name)
+		}
+	}
+	if v, ok := gaugeValue(mfs, "nasne_up", nil); !ok || v != 1 {
+		t.Fatalf("unexpected up: %v (found=%v)", v, ok)
+	}
+}
+
+func TestValidateSubCollectors(t *testing.T) {
+	if err := ValidateSubCollectors(SubCollectorNames()); err != nil {
+		t.Fatalf("all collector names should be valid: %v", err)
+	}
+	if err := ValidateSubCollectors([]string{"device", "epg"}); err == nil {
+		t.Fatal("expected error for unknown collector")
+	}
+}
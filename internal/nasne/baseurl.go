@@ -0,0 +1,100 @@
+package nasne
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WithGroupBaseURL serves the endpoints of group (GroupRecorded or
+// GroupSchedule) from rawURL, e.g. https://proxy/nasne1-recorded. The status
+// base URL stays the fallback. Invalid URLs are reported by NewClient.
+func WithGroupBaseURL(group, rawURL string) Option {
+	return func(c *Client) {
+		if c.groupURLs == nil {
+			c.groupURLs = map[string]string{}
+		}
+		c.groupURLs[group] = rawURL
+	}
+}
+
+// resolveBaseURLs sets the status, recorded and schedule base URLs.
+func (c *Client) resolveBaseURLs(rawBaseURL string) error {
+	status, err := parseBaseURL(rawBaseURL)
+	if err != nil {
+		return fmt.Errorf("base URL: %w", err)
+	}
+	proxied := status.Path != ""
+	if !proxied && status.Port() == "" {
+		status.Host = net.JoinHostPort(status.Hostname(), strconv.Itoa(defaultStatusPort))
+	}
+	c.statusBase = status
+
+	defaultGroup := status
+	if !proxied {
+		u := *status
+		u.Host = net.JoinHostPort(status.Hostname(), strconv.Itoa(defaultRecordedPort))
+		defaultGroup = &u
+	}
+	c.recordedBase, c.scheduleBase = defaultGroup, defaultGroup
+
+	for group, raw := range c.groupURLs {
+		u, err := parseBaseURL(raw)
+		if err != nil {
+			return fmt.Errorf("%s base URL: %w", group, err)
+		}
+		switch group {
+		case GroupRecorded:
+			c.recordedBase = u
+		case GroupSchedule:
+			c.scheduleBase = u
+		default:
+			return fmt.Errorf("unknown endpoint group %q", group)
+		}
+	}
+	return nil
+}
+
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", urlParseCause(err))
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("must include scheme and host")
+	}
+	if p := u.Port(); p != "" {
+		if _, err := strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid port: %w", err)
+		}
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u, nil
+}
+
+// portOf returns the port of u, defaulting to the scheme's well-known port.
+func portOf(u *url.URL) int {
+	if p, err := strconv.Atoi(u.Port()); err == nil {
+		return p
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// urlParseCause strips the URL from a url.Parse error, which would otherwise
+// repeat credentials embedded in it.
+func urlParseCause(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package nasne
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClientBaseURLs(t *testing.T) {
+	tests := []struct {
+		raw                          string
+		opts                         []Option
+		status, recorded, scheduleTo string
+	}{
+		{
+			raw:        "http://192.168.11.1",
+			status:     "http://192.168.11.1:64210",
+			recorded:   "http://192.168.11.1:64220",
+			scheduleTo: "http://192.168.11.1:64220",
+		},
+		{
+			raw:        "https://proxy.example/nasne1/",
+			status:     "https://proxy.example/nasne1",
+			recorded:   "https://proxy.example/nasne1",
+			scheduleTo: "https://proxy.example/nasne1",
+		},
+		{
+			raw:        "https://proxy.example/nasne1",
+			opts:       []Option{WithGroupBaseURL(GroupRecorded, "https://proxy.example/nasne1-recorded")},
+			status:     "https://proxy.example/nasne1",
+			recorded:   "https://proxy.example/nasne1-recorded",
+			scheduleTo: "https://proxy.example/nasne1",
+		},
+	}
+	for _, tt := range tests {
+		c, err := NewClient(tt.raw, time.Second, tt.opts...)
+		if err != nil {
+			t.Fatalf("new client %s: %v", tt.raw, err)
+		}
+		if c.statusBase.String() != tt.status || c.recordedBase.String() != tt.recorded || c.scheduleBase.String() != tt.scheduleTo {
+			t.Fatalf("%s: got status=%s recorded=%s schedule=%s", tt.raw, c.statusBase, c.recordedBase, c.scheduleBase)
+		}
+	}
+
+	if _, err := NewClient("http://192.168.11.1", time.Second, WithGroupBaseURL("status", "http://proxy/")); err == nil {
+		t.Fatal("expected error for unknown group")
+	}
+	if _, err := NewClient("http://192.168.11.1", time.Second, WithGroupBaseURL(GroupSchedule, "/relative")); err == nil {
+		t.Fatal("expected error for relative group URL")
+	}
+}
+
+func TestFetchSnapshotBehindReverseProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "exporter" || password != "secret" || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, "/nasne1")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, ok := fakeNasneResponses[path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL+"/nasne1", time.Second,
+		WithBasicAuth("exporter", "secret"),
+		WithHeader("X-Api-Key", "key"),
+	)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	s, err := c.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+	if s.Name != "living-room-nasne" || s.RecordedTitles == 0 || s.ReservedTitles == 0 {
+		t.Fatalf("unexpected snapshot: %+v", s)
+	}
+}
@@ -7,239 +7,761 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	defaultStatusPort   = 64210
 	defaultRecordedPort = 64220
-	defaultSchedulePort = 64220
+	defaultPageSize     = 100
+	defaultConcurrency  = 4
 )
 
 // Client accesses nasne HTTP APIs and extracts a stable metrics snapshot.
 type Client struct {
-	scheme      string
-	host        string
-	statusPort  int
-	httpClient  *http.Client
+	statusBase     *url.URL
+	recordedBase   *url.URL
+	scheduleBase   *url.URL
+	groupURLs      map[string]string
+	sections       map[string]bool
+	pageSize       int
+	maxConcurrency int
+	sem            chan struct{}
+	observer       RequestObserver
+	retry          RetryPolicy
+	schema         *schemaRecorder
+	transport      TransportConfig
+	userAgent      string
+	basicAuth      *url.Userinfo
+	headers        http.Header
+	httpClient     *http.Client
+
+	basesMu sync.Mutex
+	bases   map[string]*url.URL
+}
+
+// RequestEvent describes one completed HTTP request to the device.
+type RequestEvent struct {
+	Endpoint string
+	Port     int
+	// Code is the HTTP status code, or 0 when no response was received.
+	Code int
+	// Attempt is 1 for the first try and increases with every retry.
+	Attempt  int
+	Duration time.Duration
+	Err      error
+	// Reason is Reason(Err), or empty on success.
+	Reason string
+}
+
+// RequestObserver is called after every HTTP request the client makes.
+type RequestObserver func(RequestEvent)
+
+// Option configures optional Client behaviour.
+type Option func(*Client)
+
+// WithPageSize sets how many items are requested per page from list endpoints
+// such as recorded/titleListGet. Values <= 0 keep the default.
+func WithPageSize(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.pageSize = n
+		}
+	}
+}
+
+// WithMaxConcurrency limits how many HTTP requests the client sends to the
+// device at the same time. Values <= 0 keep the default.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.maxConcurrency = n
+		}
+	}
+}
+
+// WithRequestObserver registers fn to be called after every HTTP request,
+// for example to record request metrics.
+func WithRequestObserver(fn RequestObserver) Option {
+	return func(c *Client) {
+		c.observer = fn
+	}
 }
 
 // Snapshot is a normalized view used by the exporter.
 type Snapshot struct {
-	Name                   string
-	ProductName            string
-	HardwareVersion        string
-	SoftwareVersion        string
-	HDDSizeBytes           float64
-	HDDUsageBytes          float64
-	DTCPIPClients          float64
-	Recordings             float64
-	RecordedTitles         float64
-	ReservedTitles         float64
-	ReservedConflictTitles float64
-	ReservedNotFoundTitles float64
-}
-
-func NewClient(rawBaseURL string, timeout time.Duration) (*Client, error) {
+	Name                    string
+	ProductName             string
+	HardwareVersion         string
+	SoftwareVersion         string
+	HDDSizeBytes            float64
+	HDDUsageBytes           float64
+	DTCPIPClients           float64
+	Recordings              float64
+	RecordedTitles          float64
+	ReservedTitles          float64
+	ReservedConflictTitles  float64
+	ReservedNotFoundTitles  float64
+	HDDs                    []HDD
+	Reservations            []Reservation
+	TunerState              string
+	TunerChannel            TunerChannel
+	DTCPIPClientList        []DTCPIPClient
+	RecordedTitlesByGenre   map[string]float64
+	RecordedTitlesByChannel map[string]float64
+	RecordedDurationSeconds float64
+	RecordedTitlesByState   map[string]float64
+	OldestUnwatchedStart    time.Time
+
+	// Endpoints records whether each queried endpoint succeeded. A nil map
+	// means the snapshot was not built from individual endpoint calls (for
+	// example ExtractSnapshot) and every field is considered valid.
+	Endpoints map[string]bool
+	// Ports maps an endpoint group (GroupRecorded, GroupSchedule) to the
+	// port of the base URL that last answered for it.
+	Ports map[string]int
+	// SchemaReports holds the latest schema check per endpoint when the
+	// client runs with WithSchemaCheck.
+	SchemaReports []SchemaReport
+}
+
+// nasne API endpoints queried by FetchSnapshot, as used in Snapshot.Endpoints.
+const (
+	EndpointBoxName           = "status/boxNameGet"
+	EndpointSoftwareVersion   = "status/softwareVersionGet"
+	EndpointHardwareVersion   = "status/hardwareVersionGet"
+	EndpointHDDList           = "status/HDDListGet"
+	EndpointHDDInfo           = "status/HDDInfoGet"
+	EndpointDTCPIPClientList  = "status/dtcpipClientListGet"
+	EndpointBoxStatusList     = "status/boxStatusListGet"
+	EndpointRecordedTitleList = "recorded/titleListGet"
+	EndpointReservedList      = "schedule/reservedListGet"
+)
+
+// Has reports whether all the given endpoints were fetched successfully.
+func (s Snapshot) Has(endpoints ...string) bool {
+	if s.Endpoints == nil {
+		return true
+	}
+	for _, e := range endpoints {
+		if !s.Endpoints[e] {
+			return false
+		}
+	}
+	return true
+}
+
+// Reachable reports whether at least one endpoint answered successfully.
+// It is only meaningful when FetchSnapshot returned an error.
+func (s Snapshot) Reachable() bool {
+	for _, ok := range s.Endpoints {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Recorded title states used as keys of Snapshot.RecordedTitlesByState.
+// Protected titles are also counted as watched or unwatched.
+const (
+	RecordedStateUnwatched = "unwatched"
+	RecordedStateWatched   = "watched"
+	RecordedStateProtected = "protected"
+)
+
+// DTCPIPClient is a device currently connected over DTCP-IP, such as a PS4
+// running torne.
+type DTCPIPClient struct {
+	Name       string
+	IPAddress  string
+	MACAddress string
+	Type       string
+}
+
+// Tuner states reported in Snapshot.TunerState, derived from
+// boxStatusListGet tuningStatus.status.
+const (
+	TunerStateIdle      = "idle"
+	TunerStateRecording = "recording"
+	TunerStateStreaming = "streaming"
+	TunerStateEPGUpdate = "epg_update"
+	TunerStateUnknown   = "unknown"
+)
+
+// TunerStates lists every value Snapshot.TunerState can take.
+var TunerStates = []string{TunerStateIdle, TunerStateRecording, TunerStateStreaming, TunerStateEPGUpdate, TunerStateUnknown}
+
+// TunerChannel identifies the broadcast service the tuner is tuned to.
+// All fields are zero while the tuner is idle.
+type TunerChannel struct {
+	NetworkID         int
+	TransportStreamID int
+	ServiceID         int
+}
+
+// Reservation is the timing of a single entry from schedule/reservedListGet.
+type Reservation struct {
+	Start    time.Time
+	Duration time.Duration
+}
+
+// HDD describes a single drive reported by status/HDDListGet.
+type HDD struct {
+	ID         int
+	Internal   bool
+	Mounted    bool
+	Format     string
+	SizeBytes  float64
+	UsageBytes float64
+}
+
+// NewClient creates a client for the nasne at rawBaseURL, the base URL of the
+// status/* endpoints. Without a port, a plain host URL uses the nasne default
+// 64210. A URL with a path prefix (e.g. https://proxy/nasne1) is treated as a
+// reverse proxy: it keeps the scheme's default port and also serves the
+// recorded/* and schedule/* endpoints unless WithGroupBaseURL says otherwise.
+func NewClient(rawBaseURL string, timeout time.Duration, opts ...Option) (*Client, error) {
 	if rawBaseURL == "" {
 		return nil, fmt.Errorf("base URL is required")
 	}
 
-	u, err := url.Parse(rawBaseURL)
-	if err != nil {
-		return nil, fmt.Errorf("parse base URL: %w", err)
+	c := &Client{
+		pageSize:       defaultPageSize,
+		maxConcurrency: defaultConcurrency,
+		retry:          DefaultRetryPolicy(),
+		bases:          map[string]*url.URL{},
 	}
-	if u.Scheme == "" || u.Host == "" {
-		return nil, fmt.Errorf("base URL must include scheme and host")
+	for _, opt := range opts {
+		opt(c)
 	}
-
-	statusPort := defaultStatusPort
-	if p := u.Port(); p != "" {
-		parsed, err := strconv.Atoi(p)
-		if err != nil {
-			return nil, fmt.Errorf("invalid port in base URL: %w", err)
-		}
-		statusPort = parsed
+	if err := c.resolveBaseURLs(rawBaseURL); err != nil {
+		return nil, err
+	}
+	if err := c.validateSections(); err != nil {
+		return nil, err
 	}
+	transport, err := newTransport(c.transport)
+	if err != nil {
+		return nil, fmt.Errorf("configure transport: %w", err)
+	}
+	c.httpClient = &http.Client{Timeout: timeout, Transport: transport, CheckRedirect: noRedirects}
+	c.sem = make(chan struct{}, c.maxConcurrency)
+	return c, nil
+}
 
-	return &Client{
-		scheme:     u.Scheme,
-		host:       u.Hostname(),
-		statusPort: statusPort,
-		httpClient: &http.Client{Timeout: timeout},
-	}, nil
+// noRedirects stops the client at the first redirect; the 3xx response is
+// then reported as an unexpected HTTP status. nasne never redirects, and
+// following a Location header would let a device or proxy send the exporter
+// to hosts it was not configured (or, for /probe, allowed) to contact.
+func noRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
 }
 
+// CloseIdleConnections closes idle connections to the device, for clients
+// that are discarded after use.
+func (c *Client) CloseIdleConnections() {
+	c.httpClient.CloseIdleConnections()
+}
+
+// FetchSnapshot queries every endpoint needed for a Snapshot. Independent
+// endpoints are fetched concurrently, bounded by the client's concurrency
+// limit. When some endpoints fail, the returned Snapshot still holds the data
+// that was fetched, Snapshot.Endpoints records the outcome per endpoint, and
+// the error is a *FetchError.
 func (c *Client) FetchSnapshot(ctx context.Context) (Snapshot, error) {
 	var (
 		boxName  boxNameResp
 		swVer    softwareVersionResp
 		hwVer    hardwareVersionResp
-		hddList  hddListResp
 		dtcpList dtcpipClientListResp
 		boxStat  boxStatusListResp
+		hdds     []HDD
+		recorded recordedStats
+		reserved reservedStats
 	)
 
-	if err := c.getJSON(ctx, "status/boxNameGet", c.statusPort, nil, &boxName); err != nil {
-		return Snapshot{}, err
+	g := newFetchGroup()
+	if c.fetches(SectionDevice) {
+		g.Go(EndpointBoxName, func() error { return c.getJSON(ctx, EndpointBoxName, c.statusBase, nil, &boxName) })
+		g.Go(EndpointSoftwareVersion, func() error { return c.getJSON(ctx, EndpointSoftwareVersion, c.statusBase, nil, &swVer) })
+		g.Go(EndpointHardwareVersion, func() error { return c.getJSON(ctx, EndpointHardwareVersion, c.statusBase, nil, &hwVer) })
 	}
-	if err := c.getJSON(ctx, "status/softwareVersionGet", c.statusPort, nil, &swVer); err != nil {
-		return Snapshot{}, err
+	if c.fetches(SectionDTCPIP) {
+		g.Go(EndpointDTCPIPClientList, func() error { return c.getJSON(ctx, EndpointDTCPIPClientList, c.statusBase, nil, &dtcpList) })
 	}
-	if err := c.getJSON(ctx, "status/hardwareVersionGet", c.statusPort, nil, &hwVer); err != nil {
-		return Snapshot{}, err
+	if c.fetches(SectionTuner) {
+		g.Go(EndpointBoxStatusList, func() error { return c.getJSON(ctx, EndpointBoxStatusList, c.statusBase, nil, &boxStat) })
 	}
-	if err := c.getJSON(ctx, "status/HDDListGet", c.statusPort, nil, &hddList); err != nil {
-		return Snapshot{}, err
+	if c.fetches(SectionStorage) {
+		g.Go(EndpointHDDList, func() error {
+			var hddList hddListResp
+			if err := c.getJSON(ctx, EndpointHDDList, c.statusBase, nil, &hddList); err != nil {
+				return err
+			}
+			hdds = c.getHDDs(ctx, g, hddList)
+			return nil
+		})
 	}
-	if err := c.getJSON(ctx, "status/dtcpipClientListGet", c.statusPort, nil, &dtcpList); err != nil {
-		return Snapshot{}, err
+	if c.fetches(SectionRecorded) {
+		g.Go(EndpointRecordedTitleList, func() error {
+			var err error
+			recorded, err = c.getRecordedStats(ctx)
+			return err
+		})
 	}
-	if err := c.getJSON(ctx, "status/boxStatusListGet", c.statusPort, nil, &boxStat); err != nil {
-		return Snapshot{}, err
+	if c.fetches(SectionReservations) {
+		g.Go(EndpointReservedList, func() error {
+			var err error
+			reserved, err = c.getReservedStats(ctx)
+			return err
+		})
 	}
+	endpoints, err := g.Wait()
 
-	recordedTitles, err := c.getRecordedTitles(ctx)
-	if err != nil {
-		return Snapshot{}, err
+	s := Snapshot{Endpoints: endpoints, Ports: c.learnedPorts(), SchemaReports: c.SchemaReports()}
+	if s.Has(EndpointBoxName) {
+		s.Name = boxName.Name
+	}
+	if s.Has(EndpointHardwareVersion) {
+		s.ProductName = hwVer.ProductName
+		s.HardwareVersion = strconv.Itoa(hwVer.HardwareVersion)
+	}
+	if s.Has(EndpointSoftwareVersion) {
+		s.SoftwareVersion = swVer.SoftwareVersion
+	}
+	if s.Has(EndpointHDDList, EndpointHDDInfo) {
+		s.HDDs = hdds
+		for _, hdd := range hdds {
+			s.HDDSizeBytes += hdd.SizeBytes
+			s.HDDUsageBytes += hdd.UsageBytes
+		}
+	}
+	if s.Has(EndpointDTCPIPClientList) {
+		s.DTCPIPClients = float64(dtcpList.Number)
+		s.DTCPIPClientList = dtcpipClients(dtcpList)
+	}
+	if s.Has(EndpointBoxStatusList) {
+		if boxStat.TuningStatus.Status == 1 {
+			s.Recordings = 1
+		}
+		s.TunerState = tunerState(boxStat.TuningStatus.Status)
+		s.TunerChannel = TunerChannel{
+			NetworkID:         boxStat.TuningStatus.NetworkID,
+			TransportStreamID: boxStat.TuningStatus.TransportStreamID,
+			ServiceID:         boxStat.TuningStatus.ServiceID,
+		}
+	}
+	if s.Has(EndpointRecordedTitleList) {
+		s.RecordedTitles = recorded.total
+		s.RecordedTitlesByGenre = recorded.byGenre
+		s.RecordedTitlesByChannel = recorded.byChannel
+		s.RecordedDurationSeconds = recorded.durationSeconds
+		s.RecordedTitlesByState = recorded.byState
+		s.OldestUnwatchedStart = recorded.oldestUnwatched
+	}
+	if s.Has(EndpointReservedList) {
+		s.ReservedTitles = reserved.total
+		s.ReservedConflictTitles = reserved.conflict
+		s.ReservedNotFoundTitles = reserved.notFound
+		s.Reservations = reserved.reservations
+	}
+	return s, err
+}
+
+func dtcpipClients(list dtcpipClientListResp) []DTCPIPClient {
+	if len(list.Client) == 0 {
+		return nil
 	}
+	clients := make([]DTCPIPClient, 0, len(list.Client))
+	for _, cl := range list.Client {
+		clients = append(clients, DTCPIPClient{
+			Name:       cl.Name,
+			IPAddress:  cl.IPAddress,
+			MACAddress: cl.MACAddress,
+			Type:       string(cl.Type),
+		})
+	}
+	return clients
+}
 
-	reservedTitles, reservedConflict, reservedNotFound, err := c.getReservedStats(ctx)
-	if err != nil {
-		return Snapshot{}, err
+func tunerState(status int) string {
+	switch status {
+	case 0:
+		return TunerStateIdle
+	case 1:
+		return TunerStateRecording
+	case 2:
+		return TunerStateStreaming
+	case 3:
+		return TunerStateEPGUpdate
 	}
+	return TunerStateUnknown
+}
 
-	hddTotal, hddUsed, err := c.getHDDUsage(ctx, hddList)
-	if err != nil {
-		return Snapshot{}, err
-	}
-
-	recordings := 0.0
-	if boxStat.TuningStatus.Status == 1 {
-		recordings = 1
-	}
-
-	return Snapshot{
-		Name:                   boxName.Name,
-		ProductName:            hwVer.ProductName,
-		HardwareVersion:        strconv.Itoa(hwVer.HardwareVersion),
-		SoftwareVersion:        swVer.SoftwareVersion,
-		HDDSizeBytes:           hddTotal,
-		HDDUsageBytes:          hddUsed,
-		DTCPIPClients:          float64(dtcpList.Number),
-		Recordings:             recordings,
-		RecordedTitles:         recordedTitles,
-		ReservedTitles:         reservedTitles,
-		ReservedConflictTitles: reservedConflict,
-		ReservedNotFoundTitles: reservedNotFound,
-	}, nil
-}
-
-func (c *Client) getHDDUsage(ctx context.Context, list hddListResp) (total float64, used float64, err error) {
-	for _, hdd := range list.HDD {
-		q := url.Values{}
-		q.Set("id", strconv.Itoa(hdd.ID))
-		var info hddInfoResp
-		if e := c.getJSON(ctx, "status/HDDInfoGet", c.statusPort, q, &info); e != nil {
-			return 0, 0, e
-		}
-		total += info.HDD.TotalVolumeSize
-		used += info.HDD.UsedVolumeSize
+// getHDDs queues one status/HDDInfoGet per listed drive on g. The returned
+// slice is filled in once g.Wait returns.
+func (c *Client) getHDDs(ctx context.Context, g *fetchGroup, list hddListResp) []HDD {
+	hdds := make([]HDD, len(list.HDD))
+	if len(list.HDD) == 0 {
+		g.record(EndpointHDDInfo, nil)
 	}
-	return total, used, nil
+	for i, hdd := range list.HDD {
+		g.Go(EndpointHDDInfo, func() error {
+			q := url.Values{}
+			q.Set("id", strconv.Itoa(hdd.ID))
+			var info hddInfoResp
+			if err := c.getJSON(ctx, EndpointHDDInfo, c.statusBase, q, &info); err != nil {
+				return err
+			}
+			hdds[i] = HDD{
+				ID:         hdd.ID,
+				Internal:   info.HDD.InternalFlag == 1,
+				Mounted:    info.HDD.MountStatus == 1,
+				Format:     info.HDD.Format,
+				SizeBytes:  info.HDD.TotalVolumeSize,
+				UsageBytes: info.HDD.UsedVolumeSize,
+			}
+			return nil
+		})
+	}
+	return hdds
 }
 
-func (c *Client) getRecordedTitles(ctx context.Context) (float64, error) {
-	var resp titleListResp
-	q := commonListQuery()
-	if err := c.getJSONWithFallback(ctx, "recorded/titleListGet", []int{defaultRecordedPort, c.statusPort}, q, &resp); err != nil {
-		return 0, err
+type recordedStats struct {
+	total           float64
+	byGenre         map[string]float64
+	byChannel       map[string]float64
+	durationSeconds float64
+	byState         map[string]float64
+	oldestUnwatched time.Time
+}
+
+func (c *Client) getRecordedStats(ctx context.Context) (recordedStats, error) {
+	stats := recordedStats{
+		byGenre:   map[string]float64{},
+		byChannel: map[string]float64{},
+		byState: map[string]float64{
+			RecordedStateUnwatched: 0,
+			RecordedStateWatched:   0,
+			RecordedStateProtected: 0,
+		},
+	}
+	for page, err := range listPages[titleItem](ctx, c, EndpointRecordedTitleList, commonListQuery()) {
+		if err != nil {
+			return recordedStats{}, err
+		}
+		stats.total = float64(page.TotalMatches)
+		for _, item := range page.Item {
+			stats.byGenre[item.genre()]++
+			stats.byChannel[item.channel()]++
+			stats.durationSeconds += item.Duration
+			if item.ProtectFlag == 1 {
+				stats.byState[RecordedStateProtected]++
+			}
+			if item.PlayedFlag == 1 {
+				stats.byState[RecordedStateWatched]++
+				continue
+			}
+			stats.byState[RecordedStateUnwatched]++
+			if start, err := time.Parse(time.RFC3339, item.StartDateTime); err == nil {
+				if stats.oldestUnwatched.IsZero() || start.Before(stats.oldestUnwatched) {
+					stats.oldestUnwatched = start
+				}
+			}
+		}
 	}
-	return float64(resp.TotalMatches), nil
+	return stats, nil
 }
 
-func (c *Client) getReservedStats(ctx context.Context) (total float64, conflict float64, notFound float64, err error) {
-	var resp reservedListResp
+type reservedStats struct {
+	total        float64
+	conflict     float64
+	notFound     float64
+	reservations []Reservation
+}
+
+func (c *Client) getReservedStats(ctx context.Context) (reservedStats, error) {
+	var stats reservedStats
 	q := commonListQuery()
 	q.Set("withDescriptionLong", "0")
 	q.Set("withUserData", "1")
-	if err = c.getJSONWithFallback(ctx, "schedule/reservedListGet", []int{defaultSchedulePort, c.statusPort}, q, &resp); err != nil {
-		return 0, 0, 0, err
-	}
-
-	total = float64(resp.TotalMatches)
-	for _, item := range resp.Item {
-		if item.ConflictID >= 1 {
-			conflict++
+	for page, err := range listPages[reservedItem](ctx, c, EndpointReservedList, q) {
+		if err != nil {
+			return reservedStats{}, err
 		}
-		if item.EventID == 65536 {
-			notFound++
+		stats.total = float64(page.TotalMatches)
+		for _, item := range page.Item {
+			if item.ConflictID >= 1 {
+				stats.conflict++
+			}
+			if item.EventID == 65536 {
+				stats.notFound++
+			}
+			start, err := time.Parse(time.RFC3339, item.StartDateTime)
+			if err != nil {
+				continue
+			}
+			stats.reservations = append(stats.reservations, Reservation{
+				Start:    start,
+				Duration: time.Duration(item.Duration * float64(time.Second)),
+			})
 		}
 	}
-	return total, conflict, notFound, nil
+	return stats, nil
 }
 
-func commonListQuery() url.Values {
-	q := url.Values{}
-	q.Set("searchCriteria", "0")
-	q.Set("filter", "0")
-	q.Set("startingIndex", "0")
-	q.Set("requestedCount", "0")
-	q.Set("sortCriteria", "0")
-	return q
+// Endpoint groups that some devices serve on a different port than status/*.
+const (
+	GroupRecorded = "recorded"
+	GroupSchedule = "schedule"
+)
+
+func endpointGroup(endpoint string) string {
+	group, _, _ := strings.Cut(endpoint, "/")
+	return group
+}
+
+func (c *Client) groupBases(group string) []*url.URL {
+	switch group {
+	case GroupRecorded:
+		return []*url.URL{c.recordedBase, c.statusBase}
+	case GroupSchedule:
+		return []*url.URL{c.scheduleBase, c.statusBase}
+	}
+	return []*url.URL{c.statusBase}
 }
 
-func (c *Client) getJSONWithFallback(ctx context.Context, endpoint string, ports []int, query url.Values, out any) error {
+// getJSONWithFallback requests endpoint on the candidate base URLs of its
+// group. The first one that answers is remembered and tried alone on later
+// calls; the other candidates are probed again only after it fails.
+func (c *Client) getJSONWithFallback(ctx context.Context, endpoint string, query url.Values, out any) error {
+	group := endpointGroup(endpoint)
 	var lastErr error
-	seen := map[int]struct{}{}
-	for _, p := range ports {
-		if p <= 0 {
-			continue
+	seen := map[string]struct{}{}
+
+	if base, ok := c.cachedBase(group); ok {
+		err := c.getJSON(ctx, endpoint, base, query, out)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
 		}
-		if _, ok := seen[p]; ok {
+		c.forgetBase(group, base)
+		lastErr = err
+		seen[base.String()] = struct{}{}
+	}
+
+	for _, base := range c.groupBases(group) {
+		if _, ok := seen[base.String()]; ok {
 			continue
 		}
-		seen[p] = struct{}{}
-		if err := c.getJSON(ctx, endpoint, p, query, out); err != nil {
+		seen[base.String()] = struct{}{}
+		if err := c.getJSON(ctx, endpoint, base, query, out); err != nil {
 			lastErr = err
 			continue
 		}
+		c.rememberBase(group, base)
 		return nil
 	}
 	if lastErr == nil {
-		lastErr = fmt.Errorf("no valid ports")
+		lastErr = fmt.Errorf("no valid base URLs")
 	}
 	return lastErr
 }
 
-func (c *Client) getJSON(ctx context.Context, endpoint string, port int, query url.Values, out any) error {
-	u := url.URL{Scheme: c.scheme, Host: fmt.Sprintf("%s:%d", c.host, port), Path: "/" + strings.TrimLeft(endpoint, "/")}
+func (c *Client) cachedBase(group string) (*url.URL, bool) {
+	c.basesMu.Lock()
+	defer c.basesMu.Unlock()
+	base, ok := c.bases[group]
+	return base, ok
+}
+
+func (c *Client) rememberBase(group string, base *url.URL) {
+	c.basesMu.Lock()
+	defer c.basesMu.Unlock()
+	c.bases[group] = base
+}
+
+func (c *Client) forgetBase(group string, base *url.URL) {
+	c.basesMu.Lock()
+	defer c.basesMu.Unlock()
+	if c.bases[group] == base {
+		delete(c.bases, group)
+	}
+}
+
+// learnedPorts returns the port of the base URL learned for each endpoint
+// group.
+func (c *Client) learnedPorts() map[string]int {
+	c.basesMu.Lock()
+	defer c.basesMu.Unlock()
+	ports := make(map[string]int, len(c.bases))
+	for group, base := range c.bases {
+		ports[group] = portOf(base)
+	}
+	return ports
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint string, base *url.URL, query url.Values, out any) error {
+	u := *base
+	u.Path = strings.TrimRight(base.Path, "/") + "/" + strings.TrimLeft(endpoint, "/")
+	u.RawPath = ""
+	port := portOf(base)
 	if query != nil {
 		u.RawQuery = query.Encode()
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if c.retry.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.retry.Budget)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, endpoint, port, u.String(), attempt, out)
+		if err == nil {
+			return nil
+		}
+		if attempt >= c.retry.MaxAttempts || !c.retry.retryable(err) || !c.retry.wait(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+// attempt performs a single request, waiting for a concurrency slot first,
+// and reports it to the observer.
+func (c *Client) attempt(ctx context.Context, endpoint string, port int, rawURL string, attempt int, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return fmt.Errorf("create request %q: %w", endpoint, err)
 	}
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.basicAuth != nil {
+		password, _ := c.basicAuth.Password()
+		req.SetBasicAuth(c.basicAuth.Username(), password)
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-ctx.Done():
+		return fmt.Errorf("request %q: %w", endpoint, transportError(ctx.Err()))
+	}
 
+	start := time.Now()
+	code, err := c.do(req, endpoint, out)
+	if c.observer != nil {
+		e := RequestEvent{
+			Endpoint: endpoint,
+			Port:     port,
+			Code:     code,
+			Attempt:  attempt,
+			Duration: time.Since(start),
+			Err:      err,
+		}
+		if err != nil {
+			e.Reason = Reason(err)
+		}
+		c.observer(e)
+	}
+	return err
+}
+
+// do sends req and decodes the JSON response into out. It returns the HTTP
+// status code, or 0 when no response arrived.
+func (c *Client) do(req *http.Request, endpoint string, out any) (int, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request %q: %w", endpoint, err)
+		return 0, fmt.Errorf("request %q: %w", endpoint, transportError(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return fmt.Errorf("request %q: status=%d body=%q", endpoint, resp.StatusCode, strings.TrimSpace(string(body)))
+		return resp.StatusCode, fmt.Errorf("request %q: %w", endpoint, &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(body))})
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
-		return fmt.Errorf("decode %q: %w", endpoint, err)
+	if ct := resp.Header.Get("Content-Type"); isHTML(ct) {
+		return resp.StatusCode, fmt.Errorf("request %q: %w %q", endpoint, ErrUnexpectedContentType, ct)
 	}
-	return nil
+
+	if c.schema == nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode %q: %w: %w", endpoint, ErrDecode, err)
+		}
+		return resp.StatusCode, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("read %q: %w", endpoint, transportError(err))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("decode %q: %w: %w", endpoint, ErrDecode, err)
+	}
+	c.schema.check(endpoint, body, out, time.Now())
+	return resp.StatusCode, nil
+}
+
+// fetchGroup runs endpoint fetches concurrently and records the outcome of
+// each endpoint. An endpoint fetched several times (such as HDDInfoGet once
+// per drive) keeps its first error.
+type fetchGroup struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results map[string]error
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{results: map[string]error{}}
+}
+
+// Go runs f in a new goroutine. It may be called from within a running f.
+func (g *fetchGroup) Go(endpoint string, f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.record(endpoint, f())
+	}()
+}
+
+func (g *fetchGroup) record(endpoint string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if prev, ok := g.results[endpoint]; ok && (prev != nil || err == nil) {
+		return
+	}
+	g.results[endpoint] = err
+}
+
+// Wait blocks until every fetch is done and returns the per-endpoint success
+// map along with a *FetchError describing the failures, if any.
+func (g *fetchGroup) Wait() (map[string]bool, error) {
+	g.wg.Wait()
+	endpoints := make(map[string]bool, len(g.results))
+	var fe FetchError
+	for endpoint, err := range g.results {
+		endpoints[endpoint] = err == nil
+		if err != nil {
+			fe.Errors = append(fe.Errors, &EndpointError{Endpoint: endpoint, Err: err})
+		}
+	}
+	if len(fe.Errors) == 0 {
+		return endpoints, nil
+	}
+	sort.Slice(fe.Errors, func(i, j int) bool { return fe.Errors[i].Endpoint < fe.Errors[j].Endpoint })
+	return endpoints, &fe
 }
 
 type boxNameResp struct {
@@ -263,6 +785,9 @@ type hddListResp struct {
 
 type hddInfoResp struct {
 	HDD struct {
+		InternalFlag    int     `json:"internalFlag"`
+		MountStatus     int     `json:"mountStatus"`
+		Format          string  `json:"format"`
 		TotalVolumeSize float64 `json:"totalVolumeSize"`
 		UsedVolumeSize  float64 `json:"usedVolumeSize"`
 	} `json:"HDD"`
@@ -270,22 +795,114 @@ type hddInfoResp struct {
 
 type dtcpipClientListResp struct {
 	Number int `json:"number"`
+	Client []struct {
+		Name       string     `json:"name"`
+		IPAddress  string     `json:"ipAddress"`
+		MACAddress string     `json:"macAddress"`
+		Type       flexString `json:"type"`
+	} `json:"client"`
+}
+
+// flexString decodes a JSON string or number into a string, for fields whose
+// type differs between nasne firmware versions.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = flexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*f = flexString(n.String())
+	return nil
 }
 
 type boxStatusListResp struct {
 	TuningStatus struct {
-		Status int `json:"status"`
+		Status            int `json:"status"`
+		NetworkID         int `json:"networkId"`
+		TransportStreamID int `json:"transportStreamId"`
+		ServiceID         int `json:"serviceId"`
 	} `json:"tuningStatus"`
 }
 
-type titleListResp struct {
-	TotalMatches int `json:"totalMatches"`
+type titleItem struct {
+	ServiceID     int       `json:"serviceId"`
+	ChannelName   string    `json:"channelName"`
+	StartDateTime string    `json:"startDateTime"`
+	Duration      float64   `json:"duration"`
+	Genre         genreList `json:"genre"`
+	PlayedFlag    int       `json:"playedFlag"`
+	ProtectFlag   int       `json:"protectFlag"`
+}
+
+func (t titleItem) channel() string {
+	if name := strings.TrimSpace(t.ChannelName); name != "" {
+		return name
+	}
+	if t.ServiceID != 0 {
+		return strconv.Itoa(t.ServiceID)
+	}
+	return "unknown"
+}
+
+// genre maps the first genre of a title to its ARIB STD-B10 top-level
+// category. The id is the content descriptor byte (level1<<4 | level2).
+func (t titleItem) genre() string {
+	if len(t.Genre) == 0 {
+		return "unknown"
+	}
+	if name, ok := aribGenres[t.Genre[0].ID>>4]; ok {
+		return name
+	}
+	return "other"
+}
+
+var aribGenres = map[int]string{
+	0x0: "news",
+	0x1: "sports",
+	0x2: "information",
+	0x3: "drama",
+	0x4: "music",
+	0x5: "variety",
+	0x6: "movie",
+	0x7: "anime",
+	0x8: "documentary",
+	0x9: "theater",
+	0xa: "hobby",
+	0xb: "welfare",
+	0xf: "other",
+}
+
+type genre struct {
+	ID int `json:"id"`
+}
+
+// genreList accepts both a list of genres and a single genre object, which
+// some firmware versions return instead of a one-element list.
+type genreList []genre
+
+func (g *genreList) UnmarshalJSON(b []byte) error {
+	var list []genre
+	if err := json.Unmarshal(b, &list); err == nil {
+		*g = list
+		return nil
+	}
+	var single genre
+	if err := json.Unmarshal(b, &single); err != nil {
+		return err
+	}
+	*g = genreList{single}
+	return nil
 }
 
-type reservedListResp struct {
-	TotalMatches int `json:"totalMatches"`
-	Item         []struct {
-		ConflictID int `json:"conflictId"`
-		EventID    int `json:"eventId"`
-	} `json:"item"`
+type reservedItem struct {
+	ConflictID    int     `json:"conflictId"`
+	EventID       int     `json:"eventId"`
+	StartDateTime string  `json:"startDateTime"`
+	Duration      float64 `json:"duration"`
 }
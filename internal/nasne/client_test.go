@@ -0,0 +1,472 @@
+package nasne
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNasneResponses are canned payloads keyed by endpoint path.
+var fakeNasneResponses = map[string]string{
+	"/status/boxNameGet":          `{"name": "living-room-nasne"}`,
+	"/status/softwareVersionGet":  `{"softwareVersion": "4.0"}`,
+	"/status/hardwareVersionGet":  `{"productName": "nasne", "hardwareVersion": 1}`,
+	"/status/HDDListGet":          `{"HDD": [{"id": 0}, {"id": 1}]}`,
+	"/status/HDDInfoGet":          `{"HDD": {"internalFlag": 1, "mountStatus": 1, "format": "xfs", "totalVolumeSize": 1000, "usedVolumeSize": 400}}`,
+	"/status/dtcpipClientListGet": `{"number": 1, "client": [{"name": "PS4", "ipAddress": "192.168.11.20"}]}`,
+	"/status/boxStatusListGet":    `{"tuningStatus": {"status": 1, "serviceId": 1024}}`,
+	"/recorded/titleListGet":      `{"totalMatches": 1, "item": [{"serviceId": 1024, "duration": 1800, "genre": [{"id": 0}]}]}`,
+	"/schedule/reservedListGet":   `{"totalMatches": 1, "item": [{"conflictId": 1, "eventId": 1, "startDateTime": "2024-01-01T21:00:00+09:00", "duration": 1800}]}`,
+}
+
+// newFakeNasne starts a server answering every nasne endpoint after the given
+// latency, and a client whose recorded/schedule ports point at it.
+func newFakeNasne(tb testing.TB, latency time.Duration, opts ...Option) (*httptest.Server, *Client) {
+	tb.Helper()
+	return newFakeNasneWith(tb, fakeNasneResponses, latency, opts...)
+}
+
+// newFakeNasneWith is newFakeNasne with custom responses; endpoints missing
+// from responses answer 404.
+func newFakeNasneWith(tb testing.TB, responses map[string]string, latency time.Duration, opts ...Option) (*httptest.Server, *Client) {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	tb.Cleanup(srv.Close)
+
+	c, err := NewClient(srv.URL, time.Second, opts...)
+	if err != nil {
+		tb.Fatalf("new client: %v", err)
+	}
+	c.recordedBase = c.statusBase
+	c.scheduleBase = c.statusBase
+	return srv, c
+}
+
+func TestFetchSnapshot(t *testing.T) {
+	_, c := newFakeNasne(t, 0)
+
+	s, err := c.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+	if s.Name != "living-room-nasne" || s.HardwareVersion != "1" || s.SoftwareVersion != "4.0" {
+		t.Fatalf("unexpected device info: %+v", s)
+	}
+	if len(s.HDDs) != 2 || s.HDDSizeBytes != 2000 || s.HDDUsageBytes != 800 {
+		t.Fatalf("unexpected storage: hdds=%d size=%v usage=%v", len(s.HDDs), s.HDDSizeBytes, s.HDDUsageBytes)
+	}
+	if s.Recordings != 1 || s.TunerState != TunerStateRecording {
+		t.Fatalf("unexpected tuner: recordings=%v state=%q", s.Recordings, s.TunerState)
+	}
+	if s.RecordedTitles != 1 || s.ReservedTitles != 1 || s.ReservedConflictTitles != 1 {
+		t.Fatalf("unexpected list counters: %+v", s)
+	}
+}
+
+func TestFetchSnapshotPartial(t *testing.T) {
+	responses := map[string]string{}
+	for k, v := range fakeNasneResponses {
+		responses[k] = v
+	}
+	delete(responses, "/"+EndpointReservedList)
+	_, c := newFakeNasneWith(t, responses, 0)
+
+	s, err := c.FetchSnapshot(context.Background())
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		t.Fatalf("expected *FetchError, got %v", err)
+	}
+	if len(fe.Errors) != 1 || fe.Errors[0].Endpoint != EndpointReservedList {
+		t.Fatalf("unexpected endpoint errors: %v", fe)
+	}
+	if s.Has(EndpointReservedList) || !s.Has(EndpointHDDList, EndpointHDDInfo) {
+		t.Fatalf("unexpected endpoint results: %v", s.Endpoints)
+	}
+	if s.HDDSizeBytes != 2000 || s.Name != "living-room-nasne" {
+		t.Fatalf("partial snapshot lost fetched data: %+v", s)
+	}
+}
+
+func TestFetchSnapshotSections(t *testing.T) {
+	var requested []string
+	var mu sync.Mutex
+	_, c := newFakeNasne(t, 0, WithSections(SectionDevice, SectionStorage), WithRequestObserver(func(e RequestEvent) {
+		mu.Lock()
+		requested = append(requested, e.Endpoint)
+		mu.Unlock()
+	}))
+
+	s, err := c.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+	for _, e := range requested {
+		if e == EndpointRecordedTitleList || e == EndpointReservedList || e == EndpointBoxStatusList || e == EndpointDTCPIPClientList {
+			t.Fatalf("disabled section endpoint %s was requested", e)
+		}
+	}
+	if !s.Has(EndpointBoxName, EndpointHDDList, EndpointHDDInfo) || s.Has(EndpointRecordedTitleList) {
+		t.Fatalf("unexpected endpoint results: %v", s.Endpoints)
+	}
+	if _, err := NewClient("http://127.0.0.1:64210", time.Second, WithSections("epg")); err == nil {
+		t.Fatal("expected error for unknown section")
+	}
+}
+
+func BenchmarkFetchSnapshot(b *testing.B) {
+	for _, n := range []int{1, defaultConcurrency} {
+		b.Run("concurrency="+strconv.Itoa(n), func(b *testing.B) {
+			_, c := newFakeNasne(b, 5*time.Millisecond, WithMaxConcurrency(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.FetchSnapshot(context.Background()); err != nil {
+					b.Fatalf("fetch snapshot: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestListPagesWalksUntilTotalMatches(t *testing.T) {
+	const total = 25
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		start, _ := strconv.Atoi(r.URL.Query().Get("startingIndex"))
+		count, _ := strconv.Atoi(r.URL.Query().Get("requestedCount"))
+		items := []reservedItem{}
+		for i := start; i < total && i < start+count; i++ {
+			item := reservedItem{EventID: i}
+			if i%5 == 0 {
+				item.ConflictID = 1
+			}
+			items = append(items, item)
+		}
+		_ = json.NewEncoder(w).Encode(listPage[reservedItem]{TotalMatches: total, NumberReturned: len(items), Item: items})
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, time.Second, WithPageSize(10))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.scheduleBase = c.statusBase
+
+	var seen, conflicts int
+	for page, err := range listPages[reservedItem](context.Background(), c, EndpointReservedList, commonListQuery()) {
+		if err != nil {
+			t.Fatalf("list pages: %v", err)
+		}
+		seen += len(page.Item)
+		for _, item := range page.Item {
+			if item.ConflictID >= 1 {
+				conflicts++
+			}
+		}
+	}
+
+	if seen != total {
+		t.Fatalf("unexpected item count: got=%d want=%d", seen, total)
+	}
+	if conflicts != 5 {
+		t.Fatalf("unexpected conflict count: %d", conflicts)
+	}
+	if requests != 3 {
+		t.Fatalf("unexpected request count: %d", requests)
+	}
+}
+
+func TestListPagesStopsOnEmptyPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(listPage[reservedItem]{TotalMatches: 100})
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, time.Second)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.scheduleBase = c.statusBase
+
+	pages := 0
+	for _, err := range listPages[reservedItem](context.Background(), c, EndpointReservedList, commonListQuery()) {
+		if err != nil {
+			t.Fatalf("list pages: %v", err)
+		}
+		pages++
+	}
+	if pages != 1 {
+		t.Fatalf("unexpected page count: %d", pages)
+	}
+}
+
+func TestTitleItemGenreAndChannel(t *testing.T) {
+	var items []titleItem
+	payload := `[
+		{"serviceId": 1024, "channelName": "NHK総合", "duration": 1800, "genre": [{"id": 0}]},
+		{"serviceId": 2048, "duration": 3600, "genre": {"id": 113}},
+		{"duration": 60}
+	]`
+	if err := json.Unmarshal([]byte(payload), &items); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	want := []struct{ genre, channel string }{
+		{"news", "NHK総合"},
+		{"anime", "2048"},
+		{"unknown", "unknown"},
+	}
+	for i, w := range want {
+		if got := items[i].genre(); got != w.genre {
+			t.Errorf("item %d: unexpected genre %q, want %q", i, got, w.genre)
+		}
+		if got := items[i].channel(); got != w.channel {
+			t.Errorf("item %d: unexpected channel %q, want %q", i, got, w.channel)
+		}
+	}
+}
+
+func TestGetRecordedStatsStates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"totalMatches": 4, "item": [
+			{"startDateTime": "2024-01-03T21:00:00+09:00", "playedFlag": 0, "protectFlag": 0},
+			{"startDateTime": "2024-01-01T21:00:00+09:00", "playedFlag": 0, "protectFlag": 1},
+			{"startDateTime": "2023-12-01T21:00:00+09:00", "playedFlag": 1, "protectFlag": 1},
+			{"startDateTime": "2023-11-01T21:00:00+09:00", "playedFlag": 1, "protectFlag": 0}
+		]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, time.Second)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.recordedBase = c.statusBase
+
+	stats, err := c.getRecordedStats(context.Background())
+	if err != nil {
+		t.Fatalf("recorded stats: %v", err)
+	}
+	if stats.byState[RecordedStateUnwatched] != 2 || stats.byState[RecordedStateWatched] != 2 || stats.byState[RecordedStateProtected] != 2 {
+		t.Fatalf("unexpected state counts: %v", stats.byState)
+	}
+	want := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if !stats.oldestUnwatched.Equal(want) {
+		t.Fatalf("unexpected oldest unwatched: %v", stats.oldestUnwatched)
+	}
+}
+
+func TestGetJSONRetriesRetryableStatus(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "nasne"}`))
+	}))
+	defer srv.Close()
+
+	var attempts []int
+	c, err := NewClient(srv.URL, time.Second,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, RetryableStatus: []int{503}}),
+		WithRequestObserver(func(e RequestEvent) { attempts = append(attempts, e.Attempt) }),
+	)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	var resp boxNameResp
+	if err := c.getJSON(context.Background(), EndpointBoxName, c.statusBase, nil, &resp); err != nil {
+		t.Fatalf("get json: %v", err)
+	}
+	if resp.Name != "nasne" || calls != 3 {
+		t.Fatalf("unexpected result: name=%q calls=%d", resp.Name, calls)
+	}
+	if len(attempts) != 3 || attempts[2] != 3 {
+		t.Fatalf("unexpected observed attempts: %v", attempts)
+	}
+}
+
+func TestGetJSONDoesNotRetryNonRetryableStatus(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, time.Second,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableStatus: []int{503}}),
+	)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	var resp boxNameResp
+	if err := c.getJSON(context.Background(), EndpointBoxName, c.statusBase, nil, &resp); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected call count: %d", calls)
+	}
+}
+
+func TestRetryWaitRespectsDeadline(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	p.wait(ctx, 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("wait slept past the context deadline: %v", elapsed)
+	}
+}
+
+func TestGetJSONWithFallbackRemembersPort(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"totalMatches": 0}`))
+	}))
+	defer srv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	var ports []int
+	c, err := NewClient(srv.URL, time.Second, WithRequestObserver(func(e RequestEvent) { ports = append(ports, e.Port) }))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	recorded := *c.statusBase
+	recorded.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(closedPort))
+	c.recordedBase = &recorded
+	statusPort := portOf(c.statusBase)
+
+	for i := 0; i < 2; i++ {
+		var page listPage[titleItem]
+		if err := c.getJSONWithFallback(context.Background(), EndpointRecordedTitleList, commonListQuery(), &page); err != nil {
+			t.Fatalf("get json: %v", err)
+		}
+	}
+
+	want := []int{closedPort, statusPort, statusPort}
+	if len(ports) != len(want) {
+		t.Fatalf("unexpected requested ports: %v, want %v", ports, want)
+	}
+	for i := range want {
+		if ports[i] != want[i] {
+			t.Fatalf("unexpected requested ports: %v, want %v", ports, want)
+		}
+	}
+	if got := c.learnedPorts()[GroupRecorded]; got != statusPort {
+		t.Fatalf("unexpected learned port: %d", got)
+	}
+}
+
+func TestGetJSONTypedErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html>maintenance</html>"))
+		case "/status/broken":
+			_, _ = w.Write([]byte("{"))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, time.Second)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	var out map[string]any
+	err = c.getJSON(context.Background(), "status/html", c.statusBase, nil, &out)
+	if !errors.Is(err, ErrUnexpectedContentType) || Reason(err) != "content_type" {
+		t.Fatalf("unexpected error for HTML page: %v", err)
+	}
+
+	err = c.getJSON(context.Background(), "status/broken", c.statusBase, nil, &out)
+	if !errors.Is(err, ErrDecode) || Reason(err) != "decode" {
+		t.Fatalf("unexpected error for broken JSON: %v", err)
+	}
+
+	err = c.getJSON(context.Background(), "status/fail", c.statusBase, nil, &out)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusInternalServerError || !errors.Is(err, ErrHTTPStatus) {
+		t.Fatalf("unexpected error for 500: %v", err)
+	}
+
+	srv.Close()
+	err = c.getJSON(context.Background(), EndpointBoxName, c.statusBase, nil, &out)
+	if !errors.Is(err, ErrUnreachable) || Reason(err) != "unreachable" {
+		t.Fatalf("unexpected error for closed server: %v", err)
+	}
+}
+
+func TestSchemaCheckReportsDrift(t *testing.T) {
+	responses := maps.Clone(fakeNasneResponses)
+	responses["/status/HDDInfoGet"] = `{"HDD": {"internalFlag": 1, "mountStatus": 1, "format": "xfs", "totalVolumeSize": 1000, "usedVolumeSize": 250, "freeVolumeSize": 750, "smartStatus": 0}, "errorcode": 0}`
+	_, c := newFakeNasneWith(t, responses, 0, WithSchemaCheck())
+
+	s, err := c.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+
+	reports := map[string]SchemaReport{}
+	for _, r := range s.SchemaReports {
+		reports[r.Endpoint] = r
+	}
+	info, ok := reports[EndpointHDDInfo]
+	if !ok {
+		t.Fatalf("no report for %s: %+v", EndpointHDDInfo, s.SchemaReports)
+	}
+	if !slices.Equal(info.Unknown, []string{"HDD.smartStatus"}) || info.UnknownCount != 1 {
+		t.Fatalf("unexpected unknown fields, want only the new key: %+v", info)
+	}
+	if name := reports[EndpointBoxName]; name.UnknownCount != 0 || name.MissingCount != 0 {
+		t.Fatalf("unexpected drift for %s: %+v", EndpointBoxName, name)
+	}
+
+	responses["/status/boxNameGet"] = `{"boxName": "living-room-nasne"}`
+	var out struct {
+		Name string `json:"name"`
+	}
+	if err := c.getJSON(context.Background(), EndpointBoxName, c.statusBase, nil, &out); err != nil {
+		t.Fatalf("get box name: %v", err)
+	}
+	for _, r := range c.SchemaReports() {
+		if r.Endpoint != EndpointBoxName {
+			continue
+		}
+		if !slices.Equal(r.Missing, []string{"name"}) || !slices.Equal(r.Unknown, []string{"boxName"}) {
+			t.Fatalf("unexpected report after rename: %+v", r)
+		}
+	}
+}
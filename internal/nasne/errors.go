@@ -0,0 +1,110 @@
+package nasne
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"strings"
+)
+
+// Sentinel errors classifying request failures. Errors returned by Client
+// wrap one of them (or context.Canceled), so callers can use errors.Is.
+var (
+	ErrUnreachable           = errors.New("nasne unreachable")
+	ErrTimeout               = errors.New("nasne request timed out")
+	ErrHTTPStatus            = errors.New("unexpected HTTP status")
+	ErrDecode                = errors.New("invalid JSON response")
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+)
+
+// StatusError is returned for non-2xx responses. It matches ErrHTTPStatus.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status=%d body=%q", e.Code, e.Body)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrHTTPStatus
+}
+
+// Reason returns a short, metric-label friendly classification of err:
+// "unreachable", "timeout", "canceled", "http_status", "decode",
+// "content_type" or "unknown".
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnexpectedContentType):
+		return "content_type"
+	case errors.Is(err, ErrDecode):
+		return "decode"
+	case errors.Is(err, ErrHTTPStatus):
+		return "http_status"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrUnreachable):
+		return "unreachable"
+	}
+	return "unknown"
+}
+
+// transportError classifies an error from sending a request.
+func transportError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ErrUnreachable, err)
+}
+
+// isHTML reports whether a Content-Type header denotes an HTML page, which
+// nasne and proxies in front of it return for error pages.
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// EndpointError is the failure of a single nasne API endpoint.
+type EndpointError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *EndpointError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// FetchError reports every endpoint that failed during FetchSnapshot. The
+// Snapshot returned alongside it still holds the data that was fetched.
+type FetchError struct {
+	Errors []*EndpointError
+}
+
+func (e *FetchError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *FetchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
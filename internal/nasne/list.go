@@ -0,0 +1,55 @@
+package nasne
+
+import (
+	"context"
+	"iter"
+	"net/url"
+	"strconv"
+)
+
+// listPage is one page of a nasne list endpoint such as recorded/titleListGet.
+type listPage[T any] struct {
+	TotalMatches   int `json:"totalMatches"`
+	NumberReturned int `json:"numberReturned"`
+	Item           []T `json:"item"`
+}
+
+func commonListQuery() url.Values {
+	q := url.Values{}
+	q.Set("searchCriteria", "0")
+	q.Set("filter", "0")
+	q.Set("startingIndex", "0")
+	q.Set("requestedCount", "0")
+	q.Set("sortCriteria", "0")
+	return q
+}
+
+// listPages walks a list endpoint page by page, advancing startingIndex until
+// totalMatches items have been seen. Iteration also stops when the device
+// returns an empty page so a shrinking list cannot loop forever. The first
+// error is yielded and ends the iteration.
+func listPages[T any](ctx context.Context, c *Client, endpoint string, query url.Values) iter.Seq2[listPage[T], error] {
+	return func(yield func(listPage[T], error) bool) {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("requestedCount", strconv.Itoa(c.pageSize))
+
+		for index := 0; ; {
+			q.Set("startingIndex", strconv.Itoa(index))
+			var page listPage[T]
+			if err := c.getJSONWithFallback(ctx, endpoint, q, &page); err != nil {
+				yield(listPage[T]{}, err)
+				return
+			}
+			if !yield(page, nil) {
+				return
+			}
+			index += len(page.Item)
+			if len(page.Item) == 0 || index >= page.TotalMatches {
+				return
+			}
+		}
+	}
+}
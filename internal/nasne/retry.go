@@ -0,0 +1,91 @@
+package nasne
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// RetryPolicy controls how failed GET requests to the device are retried.
+// Only connection failures, timeouts and RetryableStatus responses are
+// retried; decode errors and cancelled requests never are.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	// Values <= 1 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the upper bound of the first jittered wait; it
+	// doubles on every retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RetryableStatus lists HTTP status codes worth retrying.
+	RetryableStatus []int
+	// Budget caps the total time spent on one request including retries.
+	// Zero leaves the context deadline as the only limit.
+	Budget time.Duration
+}
+
+// DefaultRetryPolicy performs a single attempt, matching the behaviour of
+// clients created without WithRetryPolicy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     1,
+		InitialBackoff:  200 * time.Millisecond,
+		MaxBackoff:      2 * time.Second,
+		RetryableStatus: []int{502, 503, 504},
+	}
+}
+
+// WithRetryPolicy sets the retry policy for idempotent GET requests.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrUnreachable):
+		return true
+	case errors.As(err, &statusErr):
+		return slices.Contains(p.RetryableStatus, statusErr.Code)
+	}
+	return false
+}
+
+// backoff returns the jittered wait before the given retry (1 for the first
+// retry), using full jitter over an exponentially growing window.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	window := p.InitialBackoff
+	for i := 1; i < retry && window < p.MaxBackoff; i++ {
+		window *= 2
+	}
+	if p.MaxBackoff > 0 && window > p.MaxBackoff {
+		window = p.MaxBackoff
+	}
+	if window <= 0 {
+		return 0
+	}
+	return rand.N(window)
+}
+
+// wait sleeps for the backoff before the next attempt. It returns false
+// without sleeping when the wait would overrun the context deadline, which
+// also carries the retry budget.
+func (p RetryPolicy) wait(ctx context.Context, retry int) bool {
+	d := p.backoff(retry)
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Add(d).Before(deadline) {
+		return false
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,214 @@
+package nasne
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSchemaSample caps how many offending keys a SchemaReport keeps.
+const maxSchemaSample = 20
+
+// SchemaReport describes how the last response of an endpoint differed from
+// the fields the client decodes. Unknown lists keys present in the payload
+// that are neither decoded nor known to be ignored (see ignoredSchemaFields),
+// so a non-zero count means the payload changed.
+// Missing lists decoded fields absent from the payload, which would
+// otherwise silently read as zero. Nested keys use dotted paths, with "[]"
+// marking list elements.
+type SchemaReport struct {
+	Endpoint     string    `json:"endpoint"`
+	Unknown      []string  `json:"unknown,omitempty"`
+	Missing      []string  `json:"missing,omitempty"`
+	UnknownCount int       `json:"unknown_count"`
+	MissingCount int       `json:"missing_count"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// WithSchemaCheck enables strict mode: every response is compared against
+// the fields the client decodes and the differences are kept per endpoint,
+// see Client.SchemaReports.
+func WithSchemaCheck() Option {
+	return func(c *Client) {
+		c.schema = &schemaRecorder{reports: map[string]SchemaReport{}}
+	}
+}
+
+// SchemaReports returns the latest report per endpoint, sorted by endpoint.
+// It returns nil unless WithSchemaCheck is set.
+func (c *Client) SchemaReports() []SchemaReport {
+	if c.schema == nil {
+		return nil
+	}
+	return c.schema.all()
+}
+
+// ignoredSchemaFields lists, per endpoint, payload keys of the nasne API that
+// the client deliberately does not decode. They are not reported as unknown.
+var ignoredSchemaFields = map[string][]string{
+	EndpointBoxName:          {"errorcode"},
+	EndpointSoftwareVersion:  {"errorcode", "backdatedVersion"},
+	EndpointHardwareVersion:  {"errorcode", "modelName"},
+	EndpointHDDList:          {"errorcode", "number", "HDD[].internalFlag", "HDD[].mountStatus", "HDD[].registerFlag"},
+	EndpointHDDInfo:          {"errorcode", "HDD.id", "HDD.registerFlag", "HDD.name", "HDD.vendorID", "HDD.productID", "HDD.serialNumber", "HDD.freeVolumeSize"},
+	EndpointDTCPIPClientList: {"errorcode", "client[].id", "client[].liveInfo"},
+	EndpointBoxStatusList:    {"errorcode", "tuningStatus.reserved", "tuningStatus.eventId", "powerStatus", "tvTimerInfoStatus", "esgStatus", "recordingStatus"},
+	EndpointRecordedTitleList: {
+		"errorcode", "item[].id", "item[].title", "item[].description", "item[].conditionId",
+		"item[].quality", "item[].eventId", "item[].recordingFlag", "item[].broadcastingType", "item[].clipNum",
+	},
+	EndpointReservedList: {
+		"errorcode", "item[].id", "item[].title", "item[].description", "item[].channelName",
+		"item[].serviceId", "item[].quality", "item[].genre", "item[].recordingFlag", "item[].broadcastingType",
+	},
+}
+
+type schemaRecorder struct {
+	mu      sync.Mutex
+	reports map[string]SchemaReport
+}
+
+func (r *schemaRecorder) all() []SchemaReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]SchemaReport, 0, len(r.reports))
+	for _, report := range r.reports {
+		out = append(out, report)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out
+}
+
+// check compares body with the type of out and stores the result.
+func (r *schemaRecorder) check(endpoint string, body []byte, out any, now time.Time) {
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return
+	}
+	unknown, missing := map[string]struct{}{}, map[string]struct{}{}
+	diffSchema(reflect.TypeOf(out), payload, "", unknown, missing)
+	for _, key := range ignoredSchemaFields[endpoint] {
+		delete(unknown, key)
+	}
+
+	report := SchemaReport{
+		Endpoint:     endpoint,
+		Unknown:      sample(unknown),
+		Missing:      sample(missing),
+		UnknownCount: len(unknown),
+		MissingCount: len(missing),
+		CheckedAt:    now,
+	}
+	r.mu.Lock()
+	r.reports[endpoint] = report
+	r.mu.Unlock()
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func diffSchema(t reflect.Type, v any, prefix string, unknown, missing map[string]struct{}) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, ok := v.([]any)
+		if !ok {
+			return
+		}
+		for _, item := range items {
+			diffSchema(t.Elem(), item, prefix+"[]", unknown, missing)
+		}
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for key, val := range obj {
+			f, ok := lookupField(fields, key)
+			if !ok {
+				unknown[joinPath(prefix, key)] = struct{}{}
+				continue
+			}
+			diffSchema(f.Type, val, joinPath(prefix, f.name), unknown, missing)
+		}
+		for _, f := range fields {
+			if _, ok := lookupKey(obj, f.name); !ok {
+				missing[joinPath(prefix, f.name)] = struct{}{}
+			}
+		}
+	}
+}
+
+type jsonField struct {
+	reflect.StructField
+	name string
+}
+
+func jsonFields(t reflect.Type) []jsonField {
+	fields := make([]jsonField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{StructField: f, name: name})
+	}
+	return fields
+}
+
+// lookupField and lookupKey match case-insensitively, like encoding/json.
+func lookupField(fields []jsonField, key string) (jsonField, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return jsonField{}, false
+}
+
+func lookupKey(obj map[string]any, name string) (any, bool) {
+	if v, ok := obj[name]; ok {
+		return v, true
+	}
+	for k, v := range obj {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func sample(keys map[string]struct{}) []string {
+	out := make([]string, 0, len(keys))
+	for k := range keys {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	if len(out) > maxSchemaSample {
+		out = out[:maxSchemaSample]
+	}
+	return out
+}
@@ -0,0 +1,46 @@
+package nasne
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Sections group the endpoints FetchSnapshot queries, so that callers which
+// do not need some metrics can skip their endpoints; see WithSections.
+const (
+	SectionDevice       = "device"       // boxNameGet, softwareVersionGet, hardwareVersionGet
+	SectionStorage      = "storage"      // HDDListGet, HDDInfoGet
+	SectionTuner        = "tuner"        // boxStatusListGet
+	SectionDTCPIP       = "dtcpip"       // dtcpipClientListGet
+	SectionRecorded     = "recorded"     // recorded/titleListGet
+	SectionReservations = "reservations" // schedule/reservedListGet
+)
+
+// Sections lists every section in the order they are documented.
+var Sections = []string{SectionDevice, SectionStorage, SectionTuner, SectionDTCPIP, SectionRecorded, SectionReservations}
+
+// WithSections limits FetchSnapshot to the endpoints of the given sections.
+// Endpoints of other sections are not requested and are absent from
+// Snapshot.Endpoints. Unknown sections are reported by NewClient.
+func WithSections(sections ...string) Option {
+	return func(c *Client) {
+		c.sections = map[string]bool{}
+		for _, s := range sections {
+			c.sections[s] = true
+		}
+	}
+}
+
+func (c *Client) validateSections() error {
+	for s := range c.sections {
+		if !slices.Contains(Sections, s) {
+			return fmt.Errorf("unknown section %q", s)
+		}
+	}
+	return nil
+}
+
+// fetches reports whether FetchSnapshot queries the endpoints of section.
+func (c *Client) fetches(section string) bool {
+	return c.sections == nil || c.sections[section]
+}
@@ -0,0 +1,205 @@
+package nasne
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportConfig tunes the HTTP transport used to talk to a device. The zero
+// value behaves like http.DefaultTransport.
+type TransportConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots, for
+	// devices behind a TLS-terminating proxy with a private CA.
+	CAFile string
+	// InsecureSkipVerify disables TLS certificate verification.
+	InsecureSkipVerify bool
+
+	// DisableKeepAlives closes the connection after every request.
+	DisableKeepAlives bool
+	// TCPKeepAlive is the TCP keep-alive period; 0 keeps the default and a
+	// negative value disables TCP keep-alives.
+	TCPKeepAlive time.Duration
+	// MaxIdleConns and MaxIdleConnsPerHost limit the idle connection pool;
+	// 0 keeps the defaults.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes idle connections after this long; 0 keeps the
+	// default.
+	IdleConnTimeout time.Duration
+
+	// Proxy routes connections through a proxy: socks5://[user:pass@]host:port
+	// or http://[user:pass@]host:port, which tunnels every connection with
+	// CONNECT. Empty keeps the proxy environment variables.
+	Proxy string
+
+	// SourceAddress is the local IP address outgoing connections bind to.
+	SourceAddress string
+	// Interface binds outgoing connections to the first address of the named
+	// network interface (IPv4 preferred). It cannot be combined with
+	// SourceAddress.
+	Interface string
+}
+
+// WithTransport sets the HTTP transport configuration. Invalid settings are
+// reported by NewClient.
+func WithTransport(cfg TransportConfig) Option {
+	return func(c *Client) {
+		c.transport = cfg
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request. An empty
+// value keeps Go's default.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithBasicAuth sends HTTP basic auth credentials with every request, for
+// example to an authenticating reverse proxy in front of the device.
+// Credentials embedded in the base URLs are used when this is not set.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.basicAuth = url.UserPassword(username, password)
+	}
+}
+
+// WithHeader adds a header sent with every request, such as an API key
+// expected by a reverse proxy.
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		if c.headers == nil {
+			c.headers = http.Header{}
+		}
+		c.headers.Add(name, value)
+	}
+}
+
+func newTransport(cfg TransportConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.CAFile != "" || cfg.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+		if cfg.CAFile != "" {
+			pool, err := loadCAFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+
+	t.DisableKeepAlives = cfg.DisableKeepAlives
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+
+	localAddr, err := sourceAddr(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if cfg.TCPKeepAlive != 0 {
+		dialer.KeepAlive = cfg.TCPKeepAlive
+	}
+	if localAddr != nil {
+		dialer.LocalAddr = localAddr
+	}
+	t.DialContext = dialer.DialContext
+
+	if cfg.Proxy != "" {
+		proxyURL, err := parseProxyURL(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL.Scheme == "http" {
+			t.Proxy = nil
+			t.DialContext = (&connectDialer{dialer: dialer, proxy: proxyURL}).DialContext
+		} else {
+			t.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	return t, nil
+}
+
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA file %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// sourceAddr resolves SourceAddress or Interface to the local TCP address to
+// dial from, or nil when neither is set.
+func sourceAddr(cfg TransportConfig) (*net.TCPAddr, error) {
+	switch {
+	case cfg.SourceAddress != "" && cfg.Interface != "":
+		return nil, fmt.Errorf("source address and interface are mutually exclusive")
+	case cfg.SourceAddress != "":
+		ip := net.ParseIP(cfg.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", cfg.SourceAddress)
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	case cfg.Interface != "":
+		ip, err := interfaceIP(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	return nil, nil
+}
+
+func interfaceIP(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup interface %q: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("list addresses of interface %q: %w", name, err)
+	}
+	var fallback net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		if fallback == nil {
+			fallback = ipNet.IP
+		}
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("interface %q has no usable address", name)
+	}
+	return fallback, nil
+}
@@ -0,0 +1,93 @@
+package nasne
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransportTLSOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "nasne"}`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     TransportConfig
+		wantErr bool
+	}{
+		{name: "untrusted", cfg: TransportConfig{}, wantErr: true},
+		{name: "ca file", cfg: TransportConfig{CAFile: caFile}},
+		{name: "insecure", cfg: TransportConfig{InsecureSkipVerify: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(srv.URL, time.Second, WithTransport(tt.cfg))
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			var out boxNameResp
+			err = c.getJSON(context.Background(), EndpointBoxName, c.statusBase, nil, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getJSON error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransportSourceAddressAndUserAgent(t *testing.T) {
+	var remoteHost, userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteHost, _, _ = net.SplitHostPort(r.RemoteAddr)
+		userAgent = r.UserAgent()
+		_, _ = w.Write([]byte(`{"name": "nasne"}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, time.Second,
+		WithTransport(TransportConfig{SourceAddress: "127.0.0.1", DisableKeepAlives: true}),
+		WithUserAgent("nasne_exporter/test"),
+	)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	var out boxNameResp
+	if err := c.getJSON(context.Background(), EndpointBoxName, c.statusBase, nil, &out); err != nil {
+		t.Fatalf("getJSON: %v", err)
+	}
+	if remoteHost != "127.0.0.1" || userAgent != "nasne_exporter/test" {
+		t.Fatalf("remote host %q, user agent %q", remoteHost, userAgent)
+	}
+}
+
+func TestTransportConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  TransportConfig
+	}{
+		{name: "bad source address", cfg: TransportConfig{SourceAddress: "not-an-ip"}},
+		{name: "unknown interface", cfg: TransportConfig{Interface: "does-not-exist0"}},
+		{name: "both", cfg: TransportConfig{SourceAddress: "127.0.0.1", Interface: "lo"}},
+		{name: "missing CA file", cfg: TransportConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient("http://127.0.0.1:64210", time.Second, WithTransport(tt.cfg)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}